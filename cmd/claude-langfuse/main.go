@@ -2,21 +2,32 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/fatih/color"
 	"github.com/urfave/cli/v2"
+	"github.com/user/claude-langfuse-go/internal/archive"
 	"github.com/user/claude-langfuse-go/internal/config"
+	"github.com/user/claude-langfuse-go/internal/langfuse"
 	"github.com/user/claude-langfuse-go/internal/monitor"
 	"github.com/user/claude-langfuse-go/internal/service"
+	"github.com/user/claude-langfuse-go/internal/state"
 	"github.com/user/claude-langfuse-go/internal/watcher"
 )
 
+// shutdownTimeout bounds how long stopping waits for queued events to be sent.
+const shutdownTimeout = 30 * time.Second
+
 func main() {
 	app := &cli.App{
 		Name:    "claude-langfuse",
@@ -26,6 +37,8 @@ func main() {
 			startCommand(),
 			configCommand(),
 			statusCommand(),
+			stateCommand(),
+			replayCommand(),
 			installServiceCommand(),
 			uninstallServiceCommand(),
 		},
@@ -115,31 +128,36 @@ func startCommand() *cli.Command {
 				return fmt.Errorf("failed to start watcher: %w", err)
 			}
 
-			// Start periodic flush ticker (every 5 seconds)
-			flushTicker := time.NewTicker(5 * time.Second)
-			done := make(chan struct{})
-			go func() {
-				for {
-					select {
-					case <-flushTicker.C:
-						mon.Flush()
-					case <-done:
-						return
-					}
-				}
-			}()
+			// Flush and save checkpoints periodically
+			mon.Start()
 
 			// Handle graceful shutdown
 			sigChan := make(chan os.Signal, 1)
 			signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
 			<-sigChan
-			close(done)
-			flushTicker.Stop()
 
 			yellow.Println("\n\nStopping monitor...")
 			w.Close()
-			mon.Shutdown()
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			if err := mon.Shutdown(ctx); err != nil {
+				color.Red("Error stopping monitor: %v", err)
+			}
+
+			stats := mon.DeliveryStats()
+			names := make([]string, 0, len(stats))
+			for name := range stats {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				s := stats[name]
+				if s.Retries+s.Requeued+s.Rejected+s.Dropped+s.Truncated > 0 {
+					gray.Printf("   %s: %d retries, %d events requeued, %d rejected, %d dropped, %d truncated\n",
+						name, s.Retries, s.Requeued, s.Rejected, s.Dropped, s.Truncated)
+				}
+			}
 			green.Println("Monitor stopped")
 
 			return nil
@@ -184,6 +202,86 @@ func configCommand() *cli.Command {
 				Name:  "assistant-trace-name",
 				Usage: "Name for assistant response traces (default: claude_response)",
 			},
+			&cli.StringFlag{
+				Name:  "content-format",
+				Usage: "Generation content format: text or chat (default: text)",
+			},
+			&cli.BoolFlag{
+				Name:  "exclude-thinking",
+				Usage: "Do not send extended thinking content (use --exclude-thinking=false to re-enable)",
+			},
+			&cli.BoolFlag{
+				Name:  "skip-media",
+				Usage: "Do not upload images and documents (use --skip-media=false to re-enable)",
+			},
+			&cli.IntFlag{
+				Name:  "max-media-bytes",
+				Usage: "Largest image or document to upload (default: 10485760)",
+			},
+			&cli.BoolFlag{
+				Name:  "disable-redaction",
+				Usage: "Send content without masking secrets (use --disable-redaction=false to re-enable)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "redact-detectors",
+				Usage: "Built-in redaction detectors: aws, github, jwt, private_key, email (default: all)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "redact-pattern",
+				Usage: "Additional regular expression to redact (repeatable)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "include-project",
+				Usage: "Only track projects matching this path glob (repeatable)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "exclude-project",
+				Usage: "Never track projects matching this path glob (repeatable)",
+			},
+			&cli.BoolFlag{
+				Name:  "metadata-only",
+				Usage: "Send timing and usage only, hashing all content (use --metadata-only=false to re-enable content)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "metadata-only-project",
+				Usage: "Project path glob to send in metadata-only mode (repeatable)",
+			},
+			&cli.BoolFlag{
+				Name:  "disable-spool",
+				Usage: "Keep unsent events in memory only (use --disable-spool=false to re-enable)",
+			},
+			&cli.StringFlag{
+				Name:  "spool-dir",
+				Usage: "Directory for unsent events (default: ~/.claude-langfuse/spool)",
+			},
+			&cli.Int64Flag{
+				Name:  "spool-max-bytes",
+				Usage: "Largest size of the spool for each connection (default: 104857600)",
+			},
+			&cli.StringFlag{
+				Name:  "spool-drop-policy",
+				Usage: "What to drop when the spool is full: oldest or newest (default: oldest)",
+			},
+			&cli.IntFlag{
+				Name:  "batch-size",
+				Usage: "Most events sent to Langfuse in one request (default: 100)",
+			},
+			&cli.IntFlag{
+				Name:  "max-batch-bytes",
+				Usage: "Largest Langfuse request body; bigger events are truncated (default: 3145728)",
+			},
+			&cli.IntFlag{
+				Name:  "flush-interval",
+				Usage: "Seconds between sends of queued events (default: 5)",
+			},
+			&cli.IntFlag{
+				Name:  "queue-size",
+				Usage: "Events that can wait to be sent before backpressure applies (default: 10000)",
+			},
+			&cli.StringFlag{
+				Name:  "backpressure",
+				Usage: "What to do with new events when the queue is full: block or drop (default: block)",
+			},
 			&cli.BoolFlag{
 				Name:  "show",
 				Usage: "Show current configuration",
@@ -236,6 +334,83 @@ func configCommand() *cli.Command {
 				if cfg.AssistantTraceName != "" {
 					gray.Printf("   assistantTraceName: %s\n", cfg.AssistantTraceName)
 				}
+				if cfg.ContentFormat != "" {
+					gray.Printf("   contentFormat: %s\n", cfg.ContentFormat)
+				}
+				if cfg.ExcludeThinking {
+					gray.Println("   excludeThinking: true")
+				}
+				if cfg.SkipMedia {
+					gray.Println("   skipMedia: true")
+				}
+				if cfg.MaxMediaBytes != 0 {
+					gray.Printf("   maxMediaBytes: %d\n", cfg.MaxMediaBytes)
+				}
+				if cfg.DisableRedaction {
+					gray.Println("   disableRedaction: true")
+				}
+				if len(cfg.RedactDetectors) > 0 {
+					gray.Printf("   redactDetectors: %s\n", strings.Join(cfg.RedactDetectors, ", "))
+				}
+				for _, pattern := range cfg.RedactPatterns {
+					gray.Printf("   redactPattern: %s\n", pattern)
+				}
+				for _, dest := range cfg.Destinations {
+					gray.Printf("   destination %s: %s\n", dest.Name, dest.Host)
+				}
+				for _, route := range cfg.Routes {
+					patterns := append(append([]string{}, route.Projects...), route.Remotes...)
+					gray.Printf("   route %s -> %s\n", strings.Join(patterns, ", "), route.Destination)
+				}
+				for _, sc := range cfg.Sinks {
+					state := "enabled"
+					if sc.Disabled {
+						state = "disabled"
+					}
+					if filters := append(append([]string{}, sc.Projects...), sc.Destinations...); len(filters) > 0 {
+						state += ", only " + strings.Join(filters, ", ")
+					}
+					gray.Printf("   sink %s (%s): %s\n", sc.Name, sc.Type, state)
+				}
+				for _, pattern := range cfg.IncludeProjects {
+					gray.Printf("   includeProject: %s\n", pattern)
+				}
+				for _, pattern := range cfg.ExcludeProjects {
+					gray.Printf("   excludeProject: %s\n", pattern)
+				}
+				if cfg.MetadataOnly {
+					gray.Println("   metadataOnly: true")
+				}
+				for _, pattern := range cfg.MetadataOnlyProjects {
+					gray.Printf("   metadataOnlyProject: %s\n", pattern)
+				}
+				if cfg.DisableSpool {
+					gray.Println("   disableSpool: true")
+				}
+				if cfg.SpoolDir != "" {
+					gray.Printf("   spoolDir: %s\n", cfg.SpoolDir)
+				}
+				if cfg.SpoolMaxBytes != 0 {
+					gray.Printf("   spoolMaxBytes: %d\n", cfg.SpoolMaxBytes)
+				}
+				if cfg.SpoolDropPolicy != "" {
+					gray.Printf("   spoolDropPolicy: %s\n", cfg.SpoolDropPolicy)
+				}
+				if cfg.BatchSize != 0 {
+					gray.Printf("   batchSize: %d\n", cfg.BatchSize)
+				}
+				if cfg.MaxBatchBytes != 0 {
+					gray.Printf("   maxBatchBytes: %d\n", cfg.MaxBatchBytes)
+				}
+				if cfg.FlushIntervalSeconds != 0 {
+					gray.Printf("   flushIntervalSeconds: %d\n", cfg.FlushIntervalSeconds)
+				}
+				if cfg.QueueSize != 0 {
+					gray.Printf("   queueSize: %d\n", cfg.QueueSize)
+				}
+				if cfg.Backpressure != "" {
+					gray.Printf("   backpressure: %s\n", cfg.Backpressure)
+				}
 
 				return nil
 			}
@@ -274,6 +449,101 @@ func configCommand() *cli.Command {
 			if v := c.String("assistant-trace-name"); v != "" {
 				cfg.AssistantTraceName = v
 			}
+			if v := c.String("content-format"); v != "" {
+				if v != config.ContentFormatText && v != config.ContentFormatChat {
+					return fmt.Errorf("invalid content format %q (expected text or chat)", v)
+				}
+				cfg.ContentFormat = v
+			}
+			if c.IsSet("exclude-thinking") {
+				cfg.ExcludeThinking = c.Bool("exclude-thinking")
+			}
+			if c.IsSet("skip-media") {
+				cfg.SkipMedia = c.Bool("skip-media")
+			}
+			if v := c.Int("max-media-bytes"); v > 0 {
+				cfg.MaxMediaBytes = v
+			}
+			if c.IsSet("disable-redaction") {
+				cfg.DisableRedaction = c.Bool("disable-redaction")
+			}
+			if c.IsSet("redact-detectors") {
+				var detectors []string
+				for _, v := range c.StringSlice("redact-detectors") {
+					for _, name := range strings.Split(v, ",") {
+						if name = strings.TrimSpace(name); name == "" {
+							continue
+						}
+						if !slices.Contains(config.DefaultRedactDetectors, name) {
+							return fmt.Errorf("unknown redaction detector %q", name)
+						}
+						detectors = append(detectors, name)
+					}
+				}
+				cfg.RedactDetectors = detectors
+			}
+			if c.IsSet("redact-pattern") {
+				for _, pattern := range c.StringSlice("redact-pattern") {
+					if _, err := regexp.Compile(pattern); err != nil {
+						return fmt.Errorf("invalid redaction pattern %q: %w", pattern, err)
+					}
+				}
+				cfg.RedactPatterns = c.StringSlice("redact-pattern")
+			}
+			if c.IsSet("metadata-only") {
+				cfg.MetadataOnly = c.Bool("metadata-only")
+			}
+			if c.IsSet("include-project") {
+				if err := validatePatterns(c.StringSlice("include-project")); err != nil {
+					return err
+				}
+				cfg.IncludeProjects = c.StringSlice("include-project")
+			}
+			if c.IsSet("exclude-project") {
+				if err := validatePatterns(c.StringSlice("exclude-project")); err != nil {
+					return err
+				}
+				cfg.ExcludeProjects = c.StringSlice("exclude-project")
+			}
+			if c.IsSet("metadata-only-project") {
+				if err := validatePatterns(c.StringSlice("metadata-only-project")); err != nil {
+					return err
+				}
+				cfg.MetadataOnlyProjects = c.StringSlice("metadata-only-project")
+			}
+			if c.IsSet("disable-spool") {
+				cfg.DisableSpool = c.Bool("disable-spool")
+			}
+			if v := c.String("spool-dir"); v != "" {
+				cfg.SpoolDir = v
+			}
+			if v := c.Int64("spool-max-bytes"); v > 0 {
+				cfg.SpoolMaxBytes = v
+			}
+			if v := c.String("spool-drop-policy"); v != "" {
+				if v != langfuse.DropOldest && v != langfuse.DropNewest {
+					return fmt.Errorf("unknown spool drop policy %q", v)
+				}
+				cfg.SpoolDropPolicy = v
+			}
+			if v := c.Int("batch-size"); v > 0 {
+				cfg.BatchSize = v
+			}
+			if v := c.Int("max-batch-bytes"); v > 0 {
+				cfg.MaxBatchBytes = v
+			}
+			if v := c.Int("flush-interval"); v > 0 {
+				cfg.FlushIntervalSeconds = v
+			}
+			if v := c.Int("queue-size"); v > 0 {
+				cfg.QueueSize = v
+			}
+			if v := c.String("backpressure"); v != "" {
+				if v != langfuse.BackpressureBlock && v != langfuse.BackpressureDrop {
+					return fmt.Errorf("unknown backpressure policy %q", v)
+				}
+				cfg.Backpressure = v
+			}
 
 			// Save config
 			if err := config.Save(cfg); err != nil {
@@ -288,6 +558,155 @@ func configCommand() *cli.Command {
 	}
 }
 
+// validatePatterns checks that project path globs are well-formed.
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid project pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func replayCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "replay",
+		Usage:     "Send events from the local archive back to the destinations that accepted them",
+		ArgsUsage: "[archive files...]",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "dir",
+				Usage: "Archive directory to replay when no files are given",
+				Value: archive.DefaultDir(),
+			},
+			&cli.StringFlag{
+				Name:  "destination",
+				Usage: "Only replay events delivered to this destination",
+			},
+			&cli.StringFlag{
+				Name:  "host",
+				Usage: "Langfuse host URL (default: the destination's host; requires --destination)",
+			},
+			&cli.StringFlag{
+				Name:  "public-key",
+				Usage: "Langfuse public key (default: the destination's key; requires --destination)",
+			},
+			&cli.StringFlag{
+				Name:  "secret-key",
+				Usage: "Langfuse secret key (default: the destination's key; requires --destination)",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Count archived events without sending them",
+			},
+		},
+		Action: func(c *cli.Context) error {
+			cyan := color.New(color.FgCyan)
+			gray := color.New(color.FgHiBlack)
+			green := color.New(color.FgGreen)
+			yellow := color.New(color.FgYellow)
+
+			cfg, err := config.Load()
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+			only := c.String("destination")
+			if only == "" && (c.String("host") != "" || c.String("public-key") != "" || c.String("secret-key") != "") {
+				return fmt.Errorf("--host and the keys can only be overridden together with --destination")
+			}
+
+			files := c.Args().Slice()
+			if len(files) == 0 {
+				if files, err = archive.Files(config.ExpandHome(c.String("dir"))); err != nil {
+					return fmt.Errorf("failed to list archive: %w", err)
+				}
+			}
+
+			// One client per destination, created on first use
+			clients := make(map[string]*langfuse.Client)
+			var names []string
+			clientFor := func(name string) (*langfuse.Client, error) {
+				if client := clients[name]; client != nil {
+					return client, nil
+				}
+				dest, ok := cfg.Destination(name)
+				if !ok {
+					return nil, fmt.Errorf("archive refers to unknown destination %q", name)
+				}
+				if v := c.String("host"); v != "" {
+					dest.Host = v
+				}
+				if v := c.String("public-key"); v != "" {
+					dest.PublicKey = v
+				}
+				if v := c.String("secret-key"); v != "" {
+					dest.SecretKey = v
+				}
+
+				client := langfuse.NewClient(dest.Host, dest.PublicKey, dest.SecretKey)
+				client.SetRejectionHandler(func(r langfuse.Rejection) {
+					color.Red("Error: %s rejected %s %s (event %s): status %d: %s", name, r.EventType, r.BodyID, r.EventID, r.Status, r.Message)
+				})
+				gray.Printf("   Replaying %s to %s\n", name, dest.Host)
+				clients[name] = client
+				names = append(names, name)
+				return client, nil
+			}
+
+			if c.Bool("dry-run") {
+				cyan.Printf("Counting %d archive files (dry run)\n", len(files))
+			} else {
+				cyan.Printf("Replaying %d archive files\n", len(files))
+			}
+
+			total, untagged := 0, 0
+			for _, path := range files {
+				count := 0
+				err := archive.ReadFile(path, func(e archive.Entry) error {
+					if e.Destination == "" {
+						untagged++
+						return nil
+					}
+					if only != "" && e.Destination != only {
+						return nil
+					}
+					count++
+					if c.Bool("dry-run") {
+						return nil
+					}
+					client, err := clientFor(e.Destination)
+					if err != nil {
+						return err
+					}
+					return client.EnqueueEvent(e.Event)
+				})
+				for _, name := range names {
+					if err != nil {
+						break
+					}
+					err = clients[name].Flush()
+				}
+				if err != nil {
+					return fmt.Errorf("failed to replay %s: %w", path, err)
+				}
+				gray.Printf("   %s: %d events\n", filepath.Base(path), count)
+				total += count
+			}
+			for _, name := range names {
+				if err := clients[name].Shutdown(c.Context); err != nil {
+					return err
+				}
+			}
+
+			if untagged > 0 {
+				yellow.Printf("Skipped %d events archived without a destination\n", untagged)
+			}
+			green.Printf("[OK] %d events replayed\n", total)
+			return nil
+		},
+	}
+}
+
 func statusCommand() *cli.Command {
 	return &cli.Command{
 		Name:  "status",
@@ -328,6 +747,24 @@ func statusCommand() *cli.Command {
 
 			gray.Printf("   Host: %s\n", cfg.Host)
 
+			for _, route := range cfg.Routes {
+				if route.Destination == config.DefaultDestination {
+					continue
+				}
+				dest, ok := cfg.Destination(route.Destination)
+				switch {
+				case !ok:
+					red.Printf("[ERR] Route refers to unknown destination %q\n", route.Destination)
+					return nil
+				case dest.PublicKey == "" || dest.SecretKey == "":
+					red.Printf("[ERR] Destination %q has no credentials\n", dest.Name)
+					return nil
+				}
+			}
+			if len(cfg.Routes) > 0 {
+				green.Printf("[OK] %d routing rules configured\n", len(cfg.Routes))
+			}
+
 			green.Println("\n[OK] Monitor ready to run")
 			gray.Println("   Start with: claude-langfuse start")
 
@@ -336,6 +773,97 @@ func statusCommand() *cli.Command {
 	}
 }
 
+func stateCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "state",
+		Usage: "Inspect or modify ingestion checkpoints",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "show",
+				Usage: "Show checkpoint summary",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:    "verbose",
+						Aliases: []string{"v"},
+						Usage:   "List every tracked file",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					cyan := color.New(color.FgCyan)
+					gray := color.New(color.FgHiBlack)
+
+					st, err := state.Open(state.DefaultFile())
+					if err != nil {
+						return err
+					}
+
+					files, messages := st.Stats()
+					cyan.Println("Ingestion checkpoints:")
+					gray.Printf("   File: %s\n", st.Path())
+					gray.Printf("   Tracked files: %d\n", files)
+					gray.Printf("   Sent messages: %d\n", messages)
+
+					if c.Bool("verbose") {
+						fmt.Println()
+						for path, fs := range st.Files() {
+							gray.Printf("   %s\n", path)
+							gray.Printf("      offset %d / %d bytes, updated %s\n",
+								fs.Offset, fs.Size, fs.UpdatedAt.Local().Format(time.RFC3339))
+						}
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:  "reset",
+				Usage: "Forget all checkpoints (history will be re-sent on next start)",
+				Action: func(c *cli.Context) error {
+					green := color.New(color.FgGreen)
+
+					st, err := state.Open(state.DefaultFile())
+					if err != nil {
+						return err
+					}
+					if err := st.Reset(); err != nil {
+						return fmt.Errorf("failed to reset state: %w", err)
+					}
+
+					green.Println("Checkpoints cleared")
+					return nil
+				},
+			},
+			{
+				Name:  "prune",
+				Usage: "Drop checkpoints for old or deleted conversations",
+				Flags: []cli.Flag{
+					&cli.DurationFlag{
+						Name:  "older-than",
+						Value: 30 * 24 * time.Hour,
+						Usage: "Remove entries not updated within this duration",
+					},
+				},
+				Action: func(c *cli.Context) error {
+					green := color.New(color.FgGreen)
+
+					st, err := state.Open(state.DefaultFile())
+					if err != nil {
+						return err
+					}
+
+					files, messages := st.Prune(c.Duration("older-than"))
+					if err := st.Save(); err != nil {
+						return fmt.Errorf("failed to save state: %w", err)
+					}
+
+					green.Printf("Pruned %d files and %d messages\n", files, messages)
+					return nil
+				},
+			},
+		},
+	}
+}
+
 func installServiceCommand() *cli.Command {
 	return &cli.Command{
 		Name:  "install-service",
@@ -0,0 +1,262 @@
+// Package archive writes delivered ingestion events to local
+// newline-delimited JSON files, rotated by day and size and compressed, and
+// reads them back for replay.
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/user/claude-langfuse-go/internal/config"
+	"github.com/user/claude-langfuse-go/internal/langfuse"
+)
+
+// DefaultMaxBytes is the size at which the active archive file is rotated.
+const DefaultMaxBytes = 64 * 1024 * 1024
+
+// activeFile is the name of the file events are appended to. Rotated files
+// are named events-<timestamp>.ndjson.gz so that they sort chronologically.
+const activeFile = "events.ndjson"
+
+// DefaultDir returns the default archive directory.
+func DefaultDir() string {
+	return filepath.Join(config.DefaultConfigDir(), "archive")
+}
+
+// Writer appends events to NDJSON files in a directory, one ingestion event
+// per line tagged with the destination it was delivered to.
+type Writer struct {
+	dir      string
+	maxBytes int64
+
+	mu        sync.Mutex
+	pending   bytes.Buffer
+	count     int
+	batchSize int
+	now       func() time.Time
+}
+
+// NewWriter creates a writer for the directory. Events are buffered and
+// written once batchSize events are pending or on Flush; the active file is
+// rotated when a write would take it past maxBytes or the day changes.
+func NewWriter(dir string, batchSize int, maxBytes int64) *Writer {
+	if batchSize <= 0 {
+		batchSize = 10
+	}
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+	return &Writer{dir: dir, batchSize: batchSize, maxBytes: maxBytes, now: time.Now}
+}
+
+// Dir returns the archive directory.
+func (w *Writer) Dir() string {
+	return w.dir
+}
+
+// Entry is an archived ingestion event and the destination that accepted it.
+type Entry struct {
+	Destination string
+	Event       langfuse.Event
+}
+
+// line is the encoding of an Entry: the ingestion event with the
+// destination added alongside its fields.
+type line struct {
+	Destination string `json:"destination,omitempty"`
+	langfuse.Event
+	Body json.RawMessage `json:"body"`
+}
+
+// Append buffers an event as encoded by the Langfuse client that delivered
+// it to destination, so the archive holds exactly what was sent.
+func (w *Writer) Append(destination string, event []byte) error {
+	var l line
+	if err := json.Unmarshal(event, &l); err != nil {
+		return fmt.Errorf("invalid event: %w", err)
+	}
+	l.Destination = destination
+	data, err := json.Marshal(l)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pending.Write(data)
+	w.pending.WriteByte('\n')
+	w.count++
+
+	if w.count >= w.batchSize {
+		return w.flushLocked()
+	}
+	return nil
+}
+
+// Flush writes all buffered events to the active file.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.flushLocked()
+}
+
+// flushLocked appends buffered events (must be called with lock held).
+func (w *Writer) flushLocked() error {
+	if w.count == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(w.dir, 0755); err != nil {
+		return err
+	}
+
+	path := filepath.Join(w.dir, activeFile)
+	if info, err := os.Stat(path); err == nil && info.Size() > 0 {
+		now := w.now()
+		sameDay := info.ModTime().Format("2006-01-02") == now.Format("2006-01-02")
+		if !sameDay || info.Size()+int64(w.pending.Len()) > w.maxBytes {
+			if err := w.rotate(path, now); err != nil {
+				return fmt.Errorf("failed to rotate archive: %w", err)
+			}
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(w.pending.Bytes()); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	w.pending.Reset()
+	w.count = 0
+	return nil
+}
+
+// rotate compresses the active file into a timestamped archive and removes it.
+func (w *Writer) rotate(path string, now time.Time) error {
+	var dst string
+	for {
+		name := fmt.Sprintf("events-%s.ndjson.gz", now.UTC().Format("20060102T150405.000000000"))
+		dst = filepath.Join(w.dir, name)
+		if _, err := os.Stat(dst); os.IsNotExist(err) {
+			break
+		}
+		now = now.Add(time.Nanosecond)
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// Compress to a temp file and rename so a crash never leaves a torn archive
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, src); err != nil {
+		out.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Shutdown writes remaining events.
+func (w *Writer) Shutdown(ctx context.Context) error {
+	return w.Flush()
+}
+
+// Files returns the archive files in a directory, oldest first, with the
+// active file last.
+func Files(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, "events-") && strings.HasSuffix(name, ".ndjson.gz") {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(files)
+
+	if _, err := os.Stat(filepath.Join(dir, activeFile)); err == nil {
+		files = append(files, filepath.Join(dir, activeFile))
+	}
+	return files, nil
+}
+
+// ReadFile calls fn for every entry in an archive file, which may be gzip
+// compressed. Event bodies are left as raw JSON so they are replayed as
+// written.
+func ReadFile(path string, fn func(Entry) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", path, err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	n := 0
+	for scanner.Scan() {
+		n++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var l line
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			return fmt.Errorf("%s:%d: invalid event: %w", path, n, err)
+		}
+		l.Event.Body = l.Body
+		if err := fn(Entry{Destination: l.Destination, Event: l.Event}); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
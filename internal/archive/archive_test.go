@@ -0,0 +1,131 @@
+package archive
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/user/claude-langfuse-go/internal/langfuse"
+)
+
+// appendEvent archives an event as a Langfuse client would encode it.
+func appendEvent(t *testing.T, w *Writer, destination string, ev langfuse.Event) {
+	t.Helper()
+	data, err := json.Marshal(ev)
+	if err != nil {
+		t.Fatalf("Failed to marshal event: %v", err)
+	}
+	if err := w.Append(destination, data); err != nil {
+		t.Fatalf("Append() failed: %v", err)
+	}
+}
+
+// readAll returns the body IDs of every archived event, oldest first.
+func readAll(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := Files(dir)
+	if err != nil {
+		t.Fatalf("Files() failed: %v", err)
+	}
+
+	var ids []string
+	for _, path := range files {
+		err := ReadFile(path, func(e Entry) error {
+			var body struct {
+				ID string `json:"id"`
+			}
+			if err := json.Unmarshal(e.Event.Body.(json.RawMessage), &body); err != nil {
+				return err
+			}
+			ids = append(ids, body.ID)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("ReadFile(%s) failed: %v", path, err)
+		}
+	}
+	return ids
+}
+
+func TestWriter_BatchesAndAppends(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "nested")
+	w := NewWriter(dir, 2, 0)
+
+	first := langfuse.NewEvent(langfuse.EventTraceCreate, &langfuse.Trace{ID: "trace-1"})
+	appendEvent(t, w, "acme", first)
+	if _, err := os.Stat(filepath.Join(dir, activeFile)); !os.IsNotExist(err) {
+		t.Error("Expected no file before the batch is full")
+	}
+
+	appendEvent(t, w, "acme", langfuse.NewEvent(langfuse.EventSpanCreate, &langfuse.Span{ID: "span-1", TraceID: "trace-1"}))
+	appendEvent(t, w, "default", langfuse.NewEvent(langfuse.EventSpanUpdate, &langfuse.Span{ID: "span-2"}))
+	if err := w.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() failed: %v", err)
+	}
+
+	ids := readAll(t, dir)
+	if strings.Join(ids, ",") != "trace-1,span-1,span-2" {
+		t.Errorf("Expected all events in order, got %v", ids)
+	}
+
+	var entries []Entry
+	if err := ReadFile(filepath.Join(dir, activeFile), func(e Entry) error {
+		entries = append(entries, e)
+		return nil
+	}); err != nil {
+		t.Fatalf("ReadFile() failed: %v", err)
+	}
+	e := entries[0]
+	if e.Destination != "acme" || e.Event.ID != first.ID || e.Event.Timestamp != first.Timestamp || e.Event.Type != langfuse.EventTraceCreate {
+		t.Errorf("Expected the event to be archived as sent, got %+v", e)
+	}
+	if entries[2].Destination != "default" {
+		t.Errorf("Expected each entry to keep its destination, got %+v", entries[2])
+	}
+
+	if err := w.Append("default", []byte("not json")); err == nil {
+		t.Error("Expected error for an invalid event")
+	}
+}
+
+func TestWriter_Rotation(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(dir, 1, 150)
+
+	day := time.Now()
+	w.now = func() time.Time { return day }
+
+	// Each event is over 100 bytes, so every write rotates by size
+	for _, id := range []string{"a", "b", "c"} {
+		appendEvent(t, w, "default", langfuse.NewEvent(langfuse.EventTraceCreate, &langfuse.Trace{ID: id}))
+	}
+
+	files, err := Files(dir)
+	if err != nil {
+		t.Fatalf("Files() failed: %v", err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("Expected 2 rotated files and the active file, got %v", files)
+	}
+	for _, path := range files[:2] {
+		if !strings.HasSuffix(path, ".ndjson.gz") {
+			t.Errorf("Expected rotated file to be compressed, got %s", path)
+		}
+	}
+
+	// A new day rotates even a small file
+	w.maxBytes = DefaultMaxBytes
+	day = day.Add(24 * time.Hour)
+	appendEvent(t, w, "default", langfuse.NewEvent(langfuse.EventTraceCreate, &langfuse.Trace{ID: "d"}))
+	if files, _ := Files(dir); len(files) != 4 {
+		t.Errorf("Expected day change to rotate, got %v", files)
+	}
+
+	if ids := readAll(t, dir); strings.Join(ids, ",") != "a,b,c,d" {
+		t.Errorf("Expected events across rotated files in order, got %v", ids)
+	}
+}
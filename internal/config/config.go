@@ -6,6 +6,11 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Config holds all configuration for the monitor.
@@ -18,8 +23,139 @@ type Config struct {
 	Source             string `json:"source"`
 	UserTraceName      string `json:"userTraceName"`
 	AssistantTraceName string `json:"assistantTraceName"`
+	ContentFormat      string `json:"contentFormat,omitempty"`
+	ExcludeThinking    bool   `json:"excludeThinking,omitempty"`
+	SkipMedia          bool   `json:"skipMedia,omitempty"`
+	MaxMediaBytes      int    `json:"maxMediaBytes,omitempty"`
+
+	// Redaction masks secrets and personal data before anything is sent
+	DisableRedaction bool     `json:"disableRedaction,omitempty"`
+	RedactDetectors  []string `json:"redactDetectors,omitempty"`
+	RedactPatterns   []string `json:"redactPatterns,omitempty"`
+
+	// Sinks are additional outputs that receive every event, or the events
+	// of the projects and destinations they are filtered to
+	Sinks []Sink `json:"sinks,omitempty"`
+
+	// Project selection by glob over project paths
+	IncludeProjects []string `json:"includeProjects,omitempty"`
+	ExcludeProjects []string `json:"excludeProjects,omitempty"`
+
+	// Routing sends projects to other Langfuse projects or hosts
+	Destinations []Destination `json:"destinations,omitempty"`
+	Routes       []Route       `json:"routes,omitempty"`
+
+	// Metadata-only mode sends timing and usage but hashes all content
+	MetadataOnly         bool     `json:"metadataOnly,omitempty"`
+	MetadataOnlyProjects []string `json:"metadataOnlyProjects,omitempty"`
+
+	// The spool keeps unsent Langfuse events on disk across outages and restarts
+	DisableSpool    bool   `json:"disableSpool,omitempty"`
+	SpoolDir        string `json:"spoolDir,omitempty"`
+	SpoolMaxBytes   int64  `json:"spoolMaxBytes,omitempty"`
+	SpoolDropPolicy string `json:"spoolDropPolicy,omitempty"` // oldest or newest
+
+	// Langfuse requests are bounded by event count and encoded size
+	BatchSize     int `json:"batchSize,omitempty"`
+	MaxBatchBytes int `json:"maxBatchBytes,omitempty"`
+
+	// Events are sent in the background; when too many are waiting, new ones
+	// wait (block) or are dropped (drop)
+	FlushIntervalSeconds int    `json:"flushIntervalSeconds,omitempty"`
+	QueueSize            int    `json:"queueSize,omitempty"`
+	Backpressure         string `json:"backpressure,omitempty"`
+}
+
+// Destination is a named Langfuse project that routes can send to.
+type Destination struct {
+	Name      string `json:"name"`
+	Host      string `json:"host"`
+	PublicKey string `json:"publicKey"`
+	SecretKey string `json:"secretKey"`
+
+	BatchSize     int `json:"batchSize,omitempty"`
+	MaxBatchBytes int `json:"maxBatchBytes,omitempty"`
+}
+
+// Route sends projects matching any of its project path globs or git remote
+// URL patterns to a destination.
+type Route struct {
+	Projects    []string `json:"projects,omitempty"`
+	Remotes     []string `json:"remotes,omitempty"`
+	Destination string   `json:"destination"`
+}
+
+// Sink is an additional output for events, configured alongside Langfuse.
+type Sink struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Disabled  bool   `json:"disabled,omitempty"`
+	Optional  bool   `json:"optional,omitempty"` // failures are logged, never block checkpoints
+	BatchSize int    `json:"batchSize,omitempty"`
+
+	// Only events of matching project path globs or routed to the named
+	// destinations are sent; a sink without filters receives every event
+	Projects     []string `json:"projects,omitempty"`
+	Destinations []string `json:"destinations,omitempty"`
+
+	// Langfuse sinks
+	Host          string `json:"host,omitempty"`
+	PublicKey     string `json:"publicKey,omitempty"`
+	SecretKey     string `json:"secretKey,omitempty"`
+	MaxBatchBytes int    `json:"maxBatchBytes,omitempty"`
+
+	// NDJSON sinks
+	Dir          string `json:"dir,omitempty"`
+	MaxFileBytes int64  `json:"maxFileBytes,omitempty"`
+
+	// OTLP and LangSmith sinks
+	Endpoint    string            `json:"endpoint,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	ServiceName string            `json:"serviceName,omitempty"`
+
+	// LangSmith sinks
+	APIKey  string `json:"apiKey,omitempty"`
+	Project string `json:"project,omitempty"`
 }
 
+// Sink types.
+const (
+	SinkLangfuse  = "langfuse"
+	SinkNDJSON    = "ndjson"
+	SinkOTLP      = "otlp"
+	SinkLangSmith = "langsmith"
+)
+
+// DefaultDestination is the name of the top-level Langfuse connection, used
+// for projects that match no route.
+const DefaultDestination = "default"
+
+// DefaultMaxMediaBytes is the largest image or document uploaded by default.
+const DefaultMaxMediaBytes = 10 * 1024 * 1024
+
+// DefaultFlushInterval is how often queued events are sent by default.
+const DefaultFlushInterval = 5 * time.Second
+
+// Built-in redaction detectors.
+const (
+	DetectorAWS        = "aws"
+	DetectorGitHub     = "github"
+	DetectorJWT        = "jwt"
+	DetectorPrivateKey = "private_key"
+	DetectorEmail      = "email"
+)
+
+// DefaultRedactDetectors lists the detectors enabled when none are configured.
+var DefaultRedactDetectors = []string{DetectorAWS, DetectorGitHub, DetectorJWT, DetectorPrivateKey, DetectorEmail}
+
+// Content formats for generation input and output.
+const (
+	// ContentFormatText flattens messages into newline-separated text.
+	ContentFormatText = "text"
+	// ContentFormatChat sends chat messages with structured content blocks.
+	ContentFormatChat = "chat"
+)
+
 // DefaultConfigDir returns the default configuration directory.
 func DefaultConfigDir() string {
 	home, err := os.UserHomeDir()
@@ -42,6 +178,40 @@ func getEnvOrDefault(envVar, defaultVal string) string {
 	return defaultVal
 }
 
+// getEnvBool returns the environment variable parsed as a boolean, or the
+// default if it is unset or invalid.
+func getEnvBool(envVar string, defaultVal bool) bool {
+	if val, err := strconv.ParseBool(os.Getenv(envVar)); err == nil {
+		return val
+	}
+	return defaultVal
+}
+
+// getEnvInt returns the environment variable parsed as an integer, or the
+// default if it is unset or invalid.
+func getEnvInt(envVar string, defaultVal int) int {
+	if val, err := strconv.Atoi(os.Getenv(envVar)); err == nil {
+		return val
+	}
+	return defaultVal
+}
+
+// getEnvList returns the environment variable split on commas, or the
+// default if it is unset.
+func getEnvList(envVar string, defaultVal []string) []string {
+	val := os.Getenv(envVar)
+	if val == "" {
+		return defaultVal
+	}
+	var list []string
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 // getCurrentUsername returns the current user's username.
 func getCurrentUsername() string {
 	u, err := user.Current()
@@ -64,6 +234,9 @@ func Load() (*Config, error) {
 		Source:             "claude_code_monitor",
 		UserTraceName:      "claude_code_user",
 		AssistantTraceName: "claude_response",
+		ContentFormat:      ContentFormatText,
+		MaxMediaBytes:      DefaultMaxMediaBytes,
+		RedactDetectors:    DefaultRedactDetectors,
 	}
 
 	// Try to load from config file
@@ -96,6 +269,75 @@ func Load() (*Config, error) {
 			if fileCfg.AssistantTraceName != "" {
 				cfg.AssistantTraceName = fileCfg.AssistantTraceName
 			}
+			if fileCfg.ContentFormat != "" {
+				cfg.ContentFormat = fileCfg.ContentFormat
+			}
+			if fileCfg.ExcludeThinking {
+				cfg.ExcludeThinking = true
+			}
+			if fileCfg.SkipMedia {
+				cfg.SkipMedia = true
+			}
+			if fileCfg.MaxMediaBytes > 0 {
+				cfg.MaxMediaBytes = fileCfg.MaxMediaBytes
+			}
+			if fileCfg.DisableRedaction {
+				cfg.DisableRedaction = true
+			}
+			if len(fileCfg.RedactDetectors) > 0 {
+				cfg.RedactDetectors = fileCfg.RedactDetectors
+			}
+			if len(fileCfg.RedactPatterns) > 0 {
+				cfg.RedactPatterns = fileCfg.RedactPatterns
+			}
+			if len(fileCfg.Destinations) > 0 {
+				cfg.Destinations = fileCfg.Destinations
+			}
+			if len(fileCfg.Routes) > 0 {
+				cfg.Routes = fileCfg.Routes
+			}
+			if len(fileCfg.Sinks) > 0 {
+				cfg.Sinks = fileCfg.Sinks
+			}
+			if len(fileCfg.IncludeProjects) > 0 {
+				cfg.IncludeProjects = fileCfg.IncludeProjects
+			}
+			if len(fileCfg.ExcludeProjects) > 0 {
+				cfg.ExcludeProjects = fileCfg.ExcludeProjects
+			}
+			if fileCfg.MetadataOnly {
+				cfg.MetadataOnly = true
+			}
+			if len(fileCfg.MetadataOnlyProjects) > 0 {
+				cfg.MetadataOnlyProjects = fileCfg.MetadataOnlyProjects
+			}
+			if fileCfg.DisableSpool {
+				cfg.DisableSpool = true
+			}
+			if fileCfg.SpoolDir != "" {
+				cfg.SpoolDir = fileCfg.SpoolDir
+			}
+			if fileCfg.SpoolMaxBytes > 0 {
+				cfg.SpoolMaxBytes = fileCfg.SpoolMaxBytes
+			}
+			if fileCfg.SpoolDropPolicy != "" {
+				cfg.SpoolDropPolicy = fileCfg.SpoolDropPolicy
+			}
+			if fileCfg.BatchSize > 0 {
+				cfg.BatchSize = fileCfg.BatchSize
+			}
+			if fileCfg.MaxBatchBytes > 0 {
+				cfg.MaxBatchBytes = fileCfg.MaxBatchBytes
+			}
+			if fileCfg.FlushIntervalSeconds > 0 {
+				cfg.FlushIntervalSeconds = fileCfg.FlushIntervalSeconds
+			}
+			if fileCfg.QueueSize > 0 {
+				cfg.QueueSize = fileCfg.QueueSize
+			}
+			if fileCfg.Backpressure != "" {
+				cfg.Backpressure = fileCfg.Backpressure
+			}
 		}
 	}
 
@@ -108,6 +350,23 @@ func Load() (*Config, error) {
 	cfg.Source = getEnvOrDefault("CLAUDE_LANGFUSE_SOURCE", cfg.Source)
 	cfg.UserTraceName = getEnvOrDefault("CLAUDE_LANGFUSE_USER_TRACE_NAME", cfg.UserTraceName)
 	cfg.AssistantTraceName = getEnvOrDefault("CLAUDE_LANGFUSE_ASSISTANT_TRACE_NAME", cfg.AssistantTraceName)
+	cfg.ContentFormat = getEnvOrDefault("CLAUDE_LANGFUSE_CONTENT_FORMAT", cfg.ContentFormat)
+	cfg.ExcludeThinking = getEnvBool("CLAUDE_LANGFUSE_EXCLUDE_THINKING", cfg.ExcludeThinking)
+	cfg.SkipMedia = getEnvBool("CLAUDE_LANGFUSE_SKIP_MEDIA", cfg.SkipMedia)
+	cfg.MaxMediaBytes = getEnvInt("CLAUDE_LANGFUSE_MAX_MEDIA_BYTES", cfg.MaxMediaBytes)
+	cfg.DisableRedaction = getEnvBool("CLAUDE_LANGFUSE_DISABLE_REDACTION", cfg.DisableRedaction)
+	cfg.RedactDetectors = getEnvList("CLAUDE_LANGFUSE_REDACT_DETECTORS", cfg.RedactDetectors)
+	cfg.IncludeProjects = getEnvList("CLAUDE_LANGFUSE_INCLUDE_PROJECTS", cfg.IncludeProjects)
+	cfg.ExcludeProjects = getEnvList("CLAUDE_LANGFUSE_EXCLUDE_PROJECTS", cfg.ExcludeProjects)
+	cfg.MetadataOnly = getEnvBool("CLAUDE_LANGFUSE_METADATA_ONLY", cfg.MetadataOnly)
+	cfg.MetadataOnlyProjects = getEnvList("CLAUDE_LANGFUSE_METADATA_ONLY_PROJECTS", cfg.MetadataOnlyProjects)
+	cfg.DisableSpool = getEnvBool("CLAUDE_LANGFUSE_DISABLE_SPOOL", cfg.DisableSpool)
+	cfg.SpoolDir = getEnvOrDefault("CLAUDE_LANGFUSE_SPOOL_DIR", cfg.SpoolDir)
+	cfg.BatchSize = getEnvInt("CLAUDE_LANGFUSE_BATCH_SIZE", cfg.BatchSize)
+	cfg.MaxBatchBytes = getEnvInt("CLAUDE_LANGFUSE_MAX_BATCH_BYTES", cfg.MaxBatchBytes)
+	cfg.FlushIntervalSeconds = getEnvInt("CLAUDE_LANGFUSE_FLUSH_INTERVAL_SECONDS", cfg.FlushIntervalSeconds)
+	cfg.QueueSize = getEnvInt("CLAUDE_LANGFUSE_QUEUE_SIZE", cfg.QueueSize)
+	cfg.Backpressure = getEnvOrDefault("CLAUDE_LANGFUSE_BACKPRESSURE", cfg.Backpressure)
 
 	return cfg, nil
 }
@@ -146,6 +405,137 @@ func LoadFromFile() (*Config, error) {
 	return &cfg, nil
 }
 
+// SpoolPath returns the directory holding the spools of all Langfuse
+// connections.
+func (c *Config) SpoolPath() string {
+	if c.SpoolDir != "" {
+		return ExpandHome(c.SpoolDir)
+	}
+	return filepath.Join(DefaultConfigDir(), "spool")
+}
+
+// FlushInterval returns how often queued events are sent.
+func (c *Config) FlushInterval() time.Duration {
+	if c.FlushIntervalSeconds > 0 {
+		return time.Duration(c.FlushIntervalSeconds) * time.Second
+	}
+	return DefaultFlushInterval
+}
+
+// Destination returns the named destination, or false if it is not defined.
+// The default destination is built from the top-level connection settings.
+func (c *Config) Destination(name string) (Destination, bool) {
+	if name == DefaultDestination {
+		return Destination{
+			Name:          name,
+			Host:          c.Host,
+			PublicKey:     c.PublicKey,
+			SecretKey:     c.SecretKey,
+			BatchSize:     c.BatchSize,
+			MaxBatchBytes: c.MaxBatchBytes,
+		}, true
+	}
+	for _, dest := range c.Destinations {
+		if dest.Name == name {
+			return dest, true
+		}
+	}
+	return Destination{}, false
+}
+
+// Route returns the destination for a project, given the URLs of its git
+// remotes. The first matching route wins; unmatched projects use the default.
+func (c *Config) Route(projectPath string, remotes []string) string {
+	for _, route := range c.Routes {
+		if MatchProject(route.Projects, projectPath) {
+			return route.Destination
+		}
+		for _, remote := range remotes {
+			if MatchRemote(route.Remotes, remote) {
+				return route.Destination
+			}
+		}
+	}
+	return DefaultDestination
+}
+
+// MatchRemote reports whether a git remote URL matches any of the patterns,
+// where * matches any sequence of characters including slashes and ? matches
+// a single character.
+func MatchRemote(patterns []string, url string) bool {
+	for _, pattern := range patterns {
+		expr := regexp.QuoteMeta(pattern)
+		expr = strings.ReplaceAll(expr, `\*`, ".*")
+		expr = strings.ReplaceAll(expr, `\?`, ".")
+		expr = "^" + expr + "$"
+		if ok, _ := regexp.MatchString(expr, url); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Accepts reports whether the sink receives events of a project routed to
+// destination. With both filters set, an event must match both.
+func (s Sink) Accepts(projectPath, destination string) bool {
+	if len(s.Projects) > 0 && !MatchProject(s.Projects, projectPath) {
+		return false
+	}
+	if len(s.Destinations) > 0 && !slices.Contains(s.Destinations, destination) {
+		return false
+	}
+	return true
+}
+
+// IsProjectIncluded reports whether the include and exclude lists allow a
+// project to be tracked. An empty include list includes every project.
+func (c *Config) IsProjectIncluded(projectPath string) bool {
+	if len(c.IncludeProjects) > 0 && !MatchProject(c.IncludeProjects, projectPath) {
+		return false
+	}
+	return !MatchProject(c.ExcludeProjects, projectPath)
+}
+
+// IsMetadataOnly reports whether content from the project must be replaced
+// by hashes before sending.
+func (c *Config) IsMetadataOnly(projectPath string) bool {
+	return c.MetadataOnly || MatchProject(c.MetadataOnlyProjects, projectPath)
+}
+
+// ExpandHome replaces a leading ~ in a path with the home directory.
+func ExpandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
+
+// MatchProject reports whether a project path matches any of the glob
+// patterns. A pattern also matches every path below a directory it matches,
+// and a leading ~ expands to the home directory.
+func MatchProject(patterns []string, projectPath string) bool {
+	if projectPath == "" {
+		return false
+	}
+	projectPath = filepath.Clean(projectPath)
+
+	for _, pattern := range patterns {
+		pattern = filepath.Clean(ExpandHome(pattern))
+
+		for dir := projectPath; ; dir = filepath.Dir(dir) {
+			if ok, _ := filepath.Match(pattern, dir); ok {
+				return true
+			}
+			if parent := filepath.Dir(dir); parent == dir {
+				break
+			}
+		}
+	}
+	return false
+}
+
 // ServiceName returns the service name from environment or default.
 func ServiceName() string {
 	return getEnvOrDefault("CLAUDE_LANGFUSE_SERVICE_NAME", "claude-langfuse-monitor")
@@ -24,6 +24,53 @@ func TestGetEnvOrDefault(t *testing.T) {
 	}
 }
 
+func TestGetEnvBool(t *testing.T) {
+	os.Setenv("TEST_BOOL", "true")
+	defer os.Unsetenv("TEST_BOOL")
+
+	if !getEnvBool("TEST_BOOL", false) {
+		t.Error("Expected true from env var")
+	}
+
+	os.Setenv("TEST_BOOL", "not-a-bool")
+	if getEnvBool("TEST_BOOL", false) {
+		t.Error("Expected default for invalid value")
+	}
+
+	if !getEnvBool("NONEXISTENT_VAR", true) {
+		t.Error("Expected default for unset env var")
+	}
+}
+
+func TestGetEnvInt(t *testing.T) {
+	os.Setenv("TEST_INT", "42")
+	defer os.Unsetenv("TEST_INT")
+
+	if v := getEnvInt("TEST_INT", 7); v != 42 {
+		t.Errorf("Expected 42 from env var, got %d", v)
+	}
+
+	os.Setenv("TEST_INT", "many")
+	if v := getEnvInt("TEST_INT", 7); v != 7 {
+		t.Errorf("Expected default for invalid value, got %d", v)
+	}
+}
+
+func TestGetEnvList(t *testing.T) {
+	os.Setenv("TEST_LIST", "aws, jwt,,email")
+	defer os.Unsetenv("TEST_LIST")
+
+	list := getEnvList("TEST_LIST", nil)
+	if len(list) != 3 || list[0] != "aws" || list[1] != "jwt" || list[2] != "email" {
+		t.Errorf("Expected [aws jwt email], got %v", list)
+	}
+
+	os.Unsetenv("TEST_LIST")
+	if list := getEnvList("TEST_LIST", []string{"github"}); len(list) != 1 || list[0] != "github" {
+		t.Errorf("Expected default for unset env var, got %v", list)
+	}
+}
+
 func TestGetCurrentUsername(t *testing.T) {
 	username := getCurrentUsername()
 	if username == "" || username == "unknown" {
@@ -37,6 +84,15 @@ func TestLoadDefaults(t *testing.T) {
 		"LANGFUSE_HOST", "LANGFUSE_PUBLIC_KEY", "LANGFUSE_SECRET_KEY",
 		"CLAUDE_LANGFUSE_USER_ID", "CLAUDE_LANGFUSE_MODEL", "CLAUDE_LANGFUSE_SOURCE",
 		"CLAUDE_LANGFUSE_USER_TRACE_NAME", "CLAUDE_LANGFUSE_ASSISTANT_TRACE_NAME",
+		"CLAUDE_LANGFUSE_CONTENT_FORMAT", "CLAUDE_LANGFUSE_EXCLUDE_THINKING",
+		"CLAUDE_LANGFUSE_SKIP_MEDIA", "CLAUDE_LANGFUSE_MAX_MEDIA_BYTES",
+		"CLAUDE_LANGFUSE_DISABLE_REDACTION", "CLAUDE_LANGFUSE_REDACT_DETECTORS",
+		"CLAUDE_LANGFUSE_METADATA_ONLY", "CLAUDE_LANGFUSE_METADATA_ONLY_PROJECTS",
+		"CLAUDE_LANGFUSE_INCLUDE_PROJECTS", "CLAUDE_LANGFUSE_EXCLUDE_PROJECTS",
+		"CLAUDE_LANGFUSE_DISABLE_SPOOL", "CLAUDE_LANGFUSE_SPOOL_DIR",
+		"CLAUDE_LANGFUSE_BATCH_SIZE", "CLAUDE_LANGFUSE_MAX_BATCH_BYTES",
+		"CLAUDE_LANGFUSE_FLUSH_INTERVAL_SECONDS", "CLAUDE_LANGFUSE_QUEUE_SIZE",
+		"CLAUDE_LANGFUSE_BACKPRESSURE",
 	}
 	for _, v := range envVars {
 		os.Unsetenv(v)
@@ -63,6 +119,27 @@ func TestLoadDefaults(t *testing.T) {
 	if cfg.AssistantTraceName != "claude_response" {
 		t.Errorf("Expected default assistantTraceName 'claude_response', got '%s'", cfg.AssistantTraceName)
 	}
+	if cfg.ContentFormat != ContentFormatText {
+		t.Errorf("Expected default contentFormat 'text', got '%s'", cfg.ContentFormat)
+	}
+	if cfg.MaxMediaBytes != DefaultMaxMediaBytes {
+		t.Errorf("Expected default maxMediaBytes %d, got %d", DefaultMaxMediaBytes, cfg.MaxMediaBytes)
+	}
+	if cfg.DisableRedaction {
+		t.Error("Expected redaction to be enabled by default")
+	}
+	if len(cfg.RedactDetectors) != len(DefaultRedactDetectors) {
+		t.Errorf("Expected default redactDetectors %v, got %v", DefaultRedactDetectors, cfg.RedactDetectors)
+	}
+	if cfg.DisableSpool {
+		t.Error("Expected spool to be enabled by default")
+	}
+	if cfg.SpoolPath() != filepath.Join(DefaultConfigDir(), "spool") {
+		t.Errorf("Expected default spool under the config dir, got %s", cfg.SpoolPath())
+	}
+	if cfg.FlushInterval() != DefaultFlushInterval {
+		t.Errorf("Expected default flush interval %v, got %v", DefaultFlushInterval, cfg.FlushInterval())
+	}
 }
 
 func TestLoadFromEnvVars(t *testing.T) {
@@ -162,6 +239,123 @@ func TestSaveAndLoadFromFile(t *testing.T) {
 	}
 }
 
+func TestMatchProject(t *testing.T) {
+	home, _ := os.UserHomeDir()
+
+	tests := []struct {
+		patterns []string
+		path     string
+		expected bool
+	}{
+		{[]string{"/work/nda/*"}, "/work/nda/client", true},
+		{[]string{"/work/nda/*"}, "/work/nda/client/sub", true},
+		{[]string{"/work/nda/*"}, "/work/open/client", false},
+		{[]string{"/work/*-secret"}, "/work/app-secret", true},
+		{[]string{"/work/app"}, "/work/application", false},
+		{[]string{"~/clients"}, filepath.Join(home, "clients", "acme"), true},
+		{nil, "/work/app", false},
+		{[]string{"*"}, "", false},
+	}
+
+	for _, tc := range tests {
+		if got := MatchProject(tc.patterns, tc.path); got != tc.expected {
+			t.Errorf("MatchProject(%v, %q) = %v, expected %v", tc.patterns, tc.path, got, tc.expected)
+		}
+	}
+}
+
+func TestRoute(t *testing.T) {
+	cfg := &Config{
+		Host:      "http://default",
+		PublicKey: "pk-default",
+		Destinations: []Destination{
+			{Name: "acme", Host: "https://cloud.langfuse.com", PublicKey: "pk-acme"},
+			{Name: "globex", Host: "https://cloud.langfuse.com", PublicKey: "pk-globex"},
+		},
+		Routes: []Route{
+			{Projects: []string{"/work/acme/*"}, Destination: "acme"},
+			{Remotes: []string{"*github.com?globex/*"}, Destination: "globex"},
+		},
+	}
+
+	tests := []struct {
+		project  string
+		remotes  []string
+		expected string
+	}{
+		{"/work/acme/api", nil, "acme"},
+		{"/work/other", []string{"git@github.com:globex/site.git"}, "globex"},
+		{"/work/other", []string{"https://github.com/globex/site"}, "globex"},
+		{"/work/other", []string{"https://github.com/initech/site"}, DefaultDestination},
+	}
+	for _, tc := range tests {
+		if got := cfg.Route(tc.project, tc.remotes); got != tc.expected {
+			t.Errorf("Route(%q, %v) = %q, expected %q", tc.project, tc.remotes, got, tc.expected)
+		}
+	}
+
+	if dest, ok := cfg.Destination(DefaultDestination); !ok || dest.PublicKey != "pk-default" {
+		t.Errorf("Expected default destination from top-level settings, got %+v", dest)
+	}
+	if dest, ok := cfg.Destination("acme"); !ok || dest.PublicKey != "pk-acme" {
+		t.Errorf("Expected acme destination, got %+v", dest)
+	}
+	if _, ok := cfg.Destination("missing"); ok {
+		t.Error("Expected unknown destination to be reported")
+	}
+}
+
+func TestIsProjectIncluded(t *testing.T) {
+	cfg := &Config{}
+	if !cfg.IsProjectIncluded("/work/app") {
+		t.Error("Expected every project to be included by default")
+	}
+
+	cfg.IncludeProjects = []string{"/work/*"}
+	cfg.ExcludeProjects = []string{"/work/private"}
+	if !cfg.IsProjectIncluded("/work/app") {
+		t.Error("Expected included project to be tracked")
+	}
+	if cfg.IsProjectIncluded("/work/private") {
+		t.Error("Expected exclude to take precedence over include")
+	}
+	if cfg.IsProjectIncluded("/home/me/scratch") {
+		t.Error("Expected project outside the include list to be skipped")
+	}
+}
+
+func TestSinkAccepts(t *testing.T) {
+	if !(Sink{}).Accepts("/work/app", DefaultDestination) {
+		t.Error("Expected sink without filters to accept every event")
+	}
+
+	sink := Sink{Projects: []string{"/work/*"}, Destinations: []string{DefaultDestination}}
+	if !sink.Accepts("/work/app", DefaultDestination) {
+		t.Error("Expected matching project and destination to be accepted")
+	}
+	if sink.Accepts("/work/app", "acme") {
+		t.Error("Expected other destination to be filtered out")
+	}
+	if sink.Accepts("/home/me/scratch", DefaultDestination) {
+		t.Error("Expected other project to be filtered out")
+	}
+}
+
+func TestIsMetadataOnly(t *testing.T) {
+	cfg := &Config{MetadataOnlyProjects: []string{"/work/nda/*"}}
+	if !cfg.IsMetadataOnly("/work/nda/client") {
+		t.Error("Expected matching project to be metadata-only")
+	}
+	if cfg.IsMetadataOnly("/work/open") {
+		t.Error("Expected other project to send content")
+	}
+
+	cfg.MetadataOnly = true
+	if !cfg.IsMetadataOnly("/work/open") {
+		t.Error("Expected global metadata-only mode to apply to every project")
+	}
+}
+
 func TestServiceName(t *testing.T) {
 	// Test default
 	os.Unsetenv("CLAUDE_LANGFUSE_SERVICE_NAME")
@@ -0,0 +1,96 @@
+package langfuse
+
+import (
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+)
+
+// DefaultBatchSize is the default number of events sent per request.
+const DefaultBatchSize = 100
+
+// DefaultMaxBatchBytes is the default limit on the size of a request,
+// below the 3.5 MB accepted by the Langfuse ingestion endpoint.
+const DefaultMaxBatchBytes = 3 * 1024 * 1024
+
+// truncationMarker replaces the end of a value shortened to fit the limit.
+const truncationMarker = "... [truncated %d bytes]"
+
+// SetMaxBatchBytes sets the largest request body to send. Events that would
+// not fit in a request on their own are truncated when queued.
+func (c *Client) SetMaxBatchBytes(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n > 0 {
+		c.maxBatchBytes = n
+	}
+}
+
+// maxEventBytes returns the largest encoded event that fits in a request.
+func (c *Client) maxEventBytes() int {
+	return c.maxBatchBytes - len(batchPrefix) - len(batchSuffix)
+}
+
+// fitEvent returns ev and its encoded size if it is within limit bytes.
+// Otherwise the largest fields of its body are shortened, keeping the start
+// of each followed by a marker, until it fits, and truncated is true.
+func fitEvent(ev Event, limit int) (fitted Event, size int, truncated bool, err error) {
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return ev, 0, false, fmt.Errorf("failed to marshal event: %w", err)
+	}
+	if len(line) <= limit {
+		return ev, len(line), false, nil
+	}
+
+	data, err := json.Marshal(ev.Body)
+	if err != nil {
+		return ev, 0, false, fmt.Errorf("failed to marshal event: %w", err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return ev, 0, false, fmt.Errorf("event of %d bytes exceeds the %d byte limit", len(line), limit)
+	}
+
+	for excess := len(line) - limit; excess > 0; excess = len(line) - limit {
+		key := ""
+		for k, v := range fields {
+			if k != "id" && (key == "" || len(v) > len(fields[key])) {
+				key = k
+			}
+		}
+		raw := fields[key]
+		if key == "" || len(raw) <= len(truncationMarker)+16 {
+			return ev, 0, false, fmt.Errorf("event of %d bytes exceeds the %d byte limit", len(line), limit)
+		}
+
+		// Cut the text of the value, leaving room for the marker
+		text := string(raw)
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			text = s
+		}
+		keep := len(text) - excess - len(truncationMarker) - 16
+		if keep < 0 {
+			keep = 0
+		}
+		for keep > 0 && !utf8.RuneStart(text[keep]) {
+			keep--
+		}
+		value := text[:keep] + fmt.Sprintf(truncationMarker, len(text)-keep)
+		fields[key], _ = json.Marshal(value)
+
+		body, _ := json.Marshal(fields)
+		ev.Body = json.RawMessage(body)
+		if line, err = json.Marshal(ev); err != nil {
+			return ev, 0, false, err
+		}
+	}
+	return ev, len(line), true, nil
+}
+
+// encodedSize returns the size of an event in a request.
+func encodedSize(ev Event) int {
+	line, _ := json.Marshal(ev)
+	return len(line)
+}
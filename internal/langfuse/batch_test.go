@@ -0,0 +1,139 @@
+package langfuse
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// batchServer records the size and event count of every request it receives.
+func batchServer(t *testing.T, sizes, counts *[]int) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		var req struct {
+			Batch []json.RawMessage `json:"batch"`
+		}
+		if err := json.Unmarshal(data, &req); err != nil {
+			t.Errorf("Failed to decode batch: %v", err)
+		}
+		*sizes = append(*sizes, len(data))
+		*counts = append(*counts, len(req.Batch))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFlush_SplitsByCount(t *testing.T) {
+	var sizes, counts []int
+	client := NewClient(batchServer(t, &sizes, &counts).URL, "pk", "sk")
+	client.SetBatchSize(100)
+
+	// Pending events bypass the sender, which would send full batches as
+	// they arrive
+	for i := 0; i < 250; i++ {
+		client.events = append(client.events, NewEvent(EventTraceCreate, &Trace{ID: "trace"}))
+	}
+	if err := client.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+
+	if len(counts) != 3 || counts[0] != 100 || counts[1] != 100 || counts[2] != 50 {
+		t.Errorf("Expected batches of 100, 100 and 50 events, got %v", counts)
+	}
+}
+
+func TestFlush_SplitsBySize(t *testing.T) {
+	var sizes, counts []int
+	client := NewClient(batchServer(t, &sizes, &counts).URL, "pk", "sk")
+	client.SetBatchSize(1000)
+	client.SetMaxBatchBytes(2000)
+
+	// Each event is a little over 500 bytes, so three fit in a request
+	for i := 0; i < 10; i++ {
+		client.CreateTrace(&Trace{ID: "trace", Input: strings.Repeat("x", 400)})
+	}
+	if err := client.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+
+	total := 0
+	for i, size := range sizes {
+		if size > 2000 {
+			t.Errorf("Expected requests within 2000 bytes, got %d", size)
+		}
+		total += counts[i]
+	}
+	if len(sizes) < 4 || total != 10 {
+		t.Errorf("Expected 10 events split across requests, got %v", counts)
+	}
+	if client.EventCount() != 0 {
+		t.Errorf("Expected no pending events, got %d", client.EventCount())
+	}
+}
+
+func TestEnqueue_TruncatesOversizedEvent(t *testing.T) {
+	var body struct {
+		ID    string `json:"id"`
+		Name  string `json:"name"`
+		Input string `json:"input"`
+	}
+	size := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		size = len(data)
+		var req struct {
+			Batch []struct {
+				Body json.RawMessage `json:"body"`
+			} `json:"batch"`
+		}
+		json.Unmarshal(data, &req)
+		if len(req.Batch) == 1 {
+			json.Unmarshal(req.Batch[0].Body, &body)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "pk", "sk")
+	client.SetMaxBatchBytes(4000)
+
+	// Multi-byte runes check that values are cut on a rune boundary
+	if err := client.CreateTrace(&Trace{ID: "trace-big", Name: "session", Input: strings.Repeat("é", 5000)}); err != nil {
+		t.Fatalf("CreateTrace() failed: %v", err)
+	}
+	if err := client.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	if client.Stats().Truncated != 1 {
+		t.Errorf("Expected 1 truncated event, got %+v", client.Stats())
+	}
+
+	if size == 0 || size > 4000 {
+		t.Errorf("Expected one request within the limit, got %d bytes", size)
+	}
+	if body.ID != "trace-big" || body.Name != "session" {
+		t.Errorf("Expected small fields kept, got %q and %q", body.ID, body.Name)
+	}
+	if !strings.HasSuffix(body.Input, " bytes]") || !strings.Contains(body.Input, "... [truncated ") {
+		t.Errorf("Expected truncation marker, got %q", body.Input)
+	}
+	if !utf8.ValidString(body.Input) {
+		t.Error("Expected truncated input to be valid UTF-8")
+	}
+}
+
+func TestEnqueue_DropsEventThatCannotFit(t *testing.T) {
+	client := NewClient("http://localhost", "pk", "sk")
+	client.SetMaxBatchBytes(100)
+
+	if err := client.CreateTrace(&Trace{ID: strings.Repeat("x", 200)}); err == nil {
+		t.Error("Expected error for an event that cannot be truncated to fit")
+	}
+	if client.EventCount() != 0 || client.Stats().Dropped != 1 {
+		t.Errorf("Expected event dropped, got %d pending, %+v", client.EventCount(), client.Stats())
+	}
+}
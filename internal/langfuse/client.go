@@ -4,6 +4,7 @@ package langfuse
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -20,13 +21,42 @@ type Client struct {
 	secretKey  string
 	httpClient *http.Client
 
-	// Batching
-	mu        sync.Mutex
-	events    []Event
-	batchSize int
+	// Batching. mu guards the client state; the sender releases it while
+	// waiting on the network.
+	mu            sync.Mutex
+	events        []Event
+	sending       int // events taken by the flush in progress
+	pendingBytes  int
+	batchSize     int
+	maxBatchBytes int
+
+	// Sender goroutine, fed through a bounded queue
+	enqueueMu     sync.Mutex // serialises producers
+	queue         chan queuedEvent
+	queueSize     int
+	backpressure  string
+	flushInterval time.Duration
+	flushReq      chan chan error
+	stop          chan struct{}
+	stopped       chan struct{}
+	startOnce     sync.Once
+	stopOnce      sync.Once
+	shutdownErr   error
+
+	// Durable queue, if enabled, and events dropped from it while queued
+	spool     *Spool
+	discarded map[string]bool
+
+	// Retries
+	retry     RetryPolicy
+	stats     DeliveryStats
+	onFailure func(err error, events int)
+	onReject  func(Rejection)
+	onDeliver func(line []byte)
+	sleep     func(time.Duration)
 }
 
-// Event represents a Langfuse event (trace or generation).
+// Event represents a Langfuse ingestion event (trace or observation).
 type Event struct {
 	ID        string      `json:"id"`
 	Timestamp string      `json:"timestamp"`
@@ -34,27 +64,82 @@ type Event struct {
 	Body      interface{} `json:"body"`
 }
 
-// Trace represents a Langfuse trace.
+// Ingestion event types.
+const (
+	EventTraceCreate      = "trace-create"
+	EventGenerationCreate = "generation-create"
+	EventGenerationUpdate = "generation-update"
+	EventSpanCreate       = "span-create"
+	EventSpanUpdate       = "span-update"
+	EventEventCreate      = "event-create"
+)
+
+// NewEvent wraps a trace or observation body in an ingestion event.
+func NewEvent(eventType string, body interface{}) Event {
+	return Event{
+		ID:        uuid.New().String(),
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+		Type:      eventType,
+		Body:      body,
+	}
+}
+
+// Trace represents a Langfuse trace. Sending a trace with an existing ID
+// updates it, so optional fields are omitted when unset.
 type Trace struct {
 	ID        string                 `json:"id"`
-	Name      string                 `json:"name"`
+	Name      string                 `json:"name,omitempty"`
 	SessionID string                 `json:"sessionId,omitempty"`
 	UserID    string                 `json:"userId,omitempty"`
 	Metadata  map[string]interface{} `json:"metadata,omitempty"`
 	Input     interface{}            `json:"input,omitempty"`
-	Timestamp time.Time              `json:"timestamp"`
+	Output    interface{}            `json:"output,omitempty"`
+	Timestamp *time.Time             `json:"timestamp,omitempty"`
 }
 
 // Generation represents a Langfuse generation.
 type Generation struct {
-	ID        string                 `json:"id"`
-	TraceID   string                 `json:"traceId,omitempty"`
-	Name      string                 `json:"name"`
-	Model     string                 `json:"model,omitempty"`
-	Metadata  map[string]interface{} `json:"metadata,omitempty"`
-	Output    interface{}            `json:"output,omitempty"`
-	StartTime time.Time              `json:"startTime"`
-	EndTime   time.Time              `json:"endTime"`
+	ID                  string                 `json:"id"`
+	TraceID             string                 `json:"traceId,omitempty"`
+	ParentObservationID string                 `json:"parentObservationId,omitempty"`
+	Name                string                 `json:"name"`
+	Model               string                 `json:"model,omitempty"`
+	Metadata            map[string]interface{} `json:"metadata,omitempty"`
+	Input               interface{}            `json:"input,omitempty"`
+	Output              interface{}            `json:"output,omitempty"`
+	UsageDetails        map[string]int         `json:"usageDetails,omitempty"`
+	StartTime           time.Time              `json:"startTime"`
+	EndTime             time.Time              `json:"endTime"`
+}
+
+// Span represents a Langfuse span observation. Times are pointers so that
+// span-update events can leave them unset.
+type Span struct {
+	ID                  string                 `json:"id"`
+	TraceID             string                 `json:"traceId,omitempty"`
+	ParentObservationID string                 `json:"parentObservationId,omitempty"`
+	Name                string                 `json:"name,omitempty"`
+	Metadata            map[string]interface{} `json:"metadata,omitempty"`
+	Input               interface{}            `json:"input,omitempty"`
+	Output              interface{}            `json:"output,omitempty"`
+	Level               string                 `json:"level,omitempty"`
+	StatusMessage       string                 `json:"statusMessage,omitempty"`
+	StartTime           *time.Time             `json:"startTime,omitempty"`
+	EndTime             *time.Time             `json:"endTime,omitempty"`
+}
+
+// EventObservation represents a Langfuse event, a point-in-time observation.
+type EventObservation struct {
+	ID                  string                 `json:"id"`
+	TraceID             string                 `json:"traceId,omitempty"`
+	ParentObservationID string                 `json:"parentObservationId,omitempty"`
+	Name                string                 `json:"name"`
+	Metadata            map[string]interface{} `json:"metadata,omitempty"`
+	Input               interface{}            `json:"input,omitempty"`
+	Output              interface{}            `json:"output,omitempty"`
+	Level               string                 `json:"level,omitempty"`
+	StatusMessage       string                 `json:"statusMessage,omitempty"`
+	StartTime           time.Time              `json:"startTime"`
 }
 
 // NewClient creates a new Langfuse client.
@@ -66,109 +151,343 @@ func NewClient(baseURL, publicKey, secretKey string) *Client {
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
-		events:    make([]Event, 0),
-		batchSize: 10,
+		events:        make([]Event, 0),
+		batchSize:     DefaultBatchSize,
+		maxBatchBytes: DefaultMaxBatchBytes,
+		queueSize:     DefaultQueueSize,
+		backpressure:  BackpressureBlock,
+		flushInterval: DefaultFlushInterval,
+		flushReq:      make(chan chan error),
+		stop:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+		discarded:     make(map[string]bool),
+		retry:         DefaultRetryPolicy,
+		sleep:         time.Sleep,
 	}
 }
 
-// CreateTrace creates a trace in Langfuse.
-func (c *Client) CreateTrace(trace *Trace) error {
+// SetBatchSize sets the number of events queued before an automatic flush.
+func (c *Client) SetBatchSize(n int) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-
-	c.events = append(c.events, Event{
-		ID:        uuid.New().String(),
-		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
-		Type:      "trace-create",
-		Body:      trace,
-	})
-
-	// Auto-flush when batch size reached
-	if len(c.events) >= c.batchSize {
-		return c.flushLocked()
+	if n > 0 {
+		c.batchSize = n
 	}
+}
 
-	return nil
+// CreateTrace creates a trace in Langfuse.
+func (c *Client) CreateTrace(trace *Trace) error {
+	return c.enqueue(EventTraceCreate, trace)
 }
 
 // CreateGeneration creates a generation in Langfuse.
 func (c *Client) CreateGeneration(gen *Generation) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	return c.enqueue(EventGenerationCreate, gen)
+}
 
-	c.events = append(c.events, Event{
-		ID:        uuid.New().String(),
-		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
-		Type:      "generation-create",
-		Body:      gen,
-	})
+// UpdateGeneration updates an existing generation, e.g. with more output.
+func (c *Client) UpdateGeneration(gen *Generation) error {
+	return c.enqueue(EventGenerationUpdate, gen)
+}
 
-	// Auto-flush when batch size reached
-	if len(c.events) >= c.batchSize {
-		return c.flushLocked()
+// CreateSpan creates a span in Langfuse.
+func (c *Client) CreateSpan(span *Span) error {
+	return c.enqueue(EventSpanCreate, span)
+}
+
+// UpdateSpan updates an existing span, e.g. to set its end time and output.
+func (c *Client) UpdateSpan(span *Span) error {
+	return c.enqueue(EventSpanUpdate, span)
+}
+
+// CreateEvent creates an event observation in Langfuse.
+func (c *Client) CreateEvent(ev *EventObservation) error {
+	return c.enqueue(EventEventCreate, ev)
+}
+
+// Export queues an event of any ingestion type.
+func (c *Client) Export(eventType string, body interface{}) error {
+	return c.enqueue(eventType, body)
+}
+
+// EnqueueEvent queues a previously recorded event unchanged, keeping its ID
+// so that Langfuse can deduplicate repeated deliveries. It returns once the
+// event is queued, without waiting for it to be sent; with the blocking
+// backpressure policy it waits while the queue is full.
+func (c *Client) EnqueueEvent(ev Event) error {
+	c.start()
+	c.enqueueMu.Lock()
+	defer c.enqueueMu.Unlock()
+	select {
+	case <-c.stop:
+		return ErrClosed
+	default:
 	}
 
-	return nil
+	qe, err := c.accept(ev)
+	if err != nil {
+		return err
+	}
+	return c.send(qe)
 }
 
-// Flush sends all pending events to Langfuse.
-func (c *Client) Flush() error {
+// accept fits an event to the batch size limit and persists it to the
+// spool, if any.
+func (c *Client) accept(ev Event) (queuedEvent, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	return c.flushLocked()
+
+	// Shorten events too large to be sent in any batch
+	ev, size, truncated, err := fitEvent(ev, c.maxEventBytes())
+	if err != nil {
+		c.stats.Dropped++
+		return queuedEvent{}, fmt.Errorf("event %s dropped: %w", ev.ID, err)
+	}
+	if truncated {
+		c.stats.Truncated++
+	}
+
+	// Persist the event before accepting it
+	if c.spool != nil {
+		dropped, err := c.spool.Append(ev)
+		c.discardLocked(dropped)
+		if err != nil {
+			c.stats.Dropped++
+			return queuedEvent{}, fmt.Errorf("failed to spool event: %w", err)
+		}
+	}
+	return queuedEvent{ev: ev, size: size}, nil
 }
 
-// flushLocked sends events (must be called with lock held).
+// enqueue adds an event to the batch.
+func (c *Client) enqueue(eventType string, body interface{}) error {
+	return c.EnqueueEvent(NewEvent(eventType, body))
+}
+
+// flushLocked sends events in batches bounded by count and size (must be
+// called with lock held, by the sender).
 func (c *Client) flushLocked() error {
 	if len(c.events) == 0 {
 		return nil
 	}
 
-	// Create batch request
-	payload := map[string]interface{}{
-		"batch": c.events,
+	var sent []Event
+	if c.spool != nil {
+		if err := c.spool.Sync(); err != nil {
+			return fmt.Errorf("failed to sync spool: %w", err)
+		}
+		sent = append(sent, c.events...)
 	}
 
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("failed to marshal events: %w", err)
+	queue := c.events
+	c.events = nil
+	c.sending = len(queue)
+	var kept []Event
+	var firstErr error
+	for len(queue) > 0 {
+		var batch []Event
+		var lines [][]byte
+		batch, lines, queue = c.nextBatch(queue)
+		pending, err := c.deliverLocked(batch, lines)
+		kept = append(kept, pending...)
+		if err == nil {
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		// Only a rejected batch is specific to its events; for anything
+		// else the rest of the queue would fail the same way
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || !rejectedBatch(apiErr) {
+			break
+		}
+	}
+	c.events = append(kept, queue...)
+	c.sending = 0
+	c.pendingBytes = 0
+	for _, ev := range c.events {
+		c.pendingBytes += encodedSize(ev)
+	}
+
+	err := firstErr
+	if err != nil && len(c.events) > 0 {
+		c.failedLocked(err)
+		// Spooled events are safe on disk and sent on the next flush
+		if c.spool != nil {
+			err = nil
+		}
 	}
 
-	// Create request
+	if c.spool != nil {
+		if ackErr := c.ackLocked(sent); ackErr != nil && err == nil {
+			err = ackErr
+		}
+	}
+	return err
+}
+
+// nextBatch takes events from the front of queue, up to the batch size and
+// without exceeding the byte limit, and returns them with their encoding and
+// the rest of the queue. At least one event is always taken.
+func (c *Client) nextBatch(queue []Event) ([]Event, [][]byte, []Event) {
+	var batch []Event
+	var lines [][]byte
+	size := len(batchPrefix) + len(batchSuffix)
+	for len(queue) > 0 && len(batch) < c.batchSize {
+		if c.discarded[queue[0].ID] {
+			// Dropped from a full spool since it was queued
+			delete(c.discarded, queue[0].ID)
+			queue = queue[1:]
+			continue
+		}
+		line, err := json.Marshal(queue[0])
+		if err != nil {
+			// Unencodable events can never be sent
+			c.stats.Dropped++
+			queue = queue[1:]
+			continue
+		}
+		if len(batch) > 0 && size+len(line)+1 > c.maxBatchBytes {
+			break
+		}
+		batch = append(batch, queue[0])
+		lines = append(lines, line)
+		size += len(line) + 1
+		queue = queue[1:]
+	}
+	return batch, lines, queue
+}
+
+// deliverLocked sends one batch with retries and returns the events that
+// are still pending afterwards (must be called with lock held; it is
+// released during requests).
+func (c *Client) deliverLocked(batch []Event, lines [][]byte) ([]Event, error) {
+	if len(batch) == 0 {
+		return nil, nil
+	}
+
+	// Each attempt sends only the events still pending: accepted and
+	// permanently rejected events are removed after every response
+	requeued := make(map[string]bool)
+	err := c.withRetry(func() error {
+		body := encodeBatch(lines)
+		c.mu.Unlock()
+		resp, err := c.post(body)
+		c.mu.Lock()
+		if err != nil {
+			return err
+		}
+		accepted, err := c.settleLocked(resp, &batch, &lines, requeued)
+		c.notifyDelivered(accepted)
+		return err
+	})
+
+	// A rejected batch will never be accepted, so drop it rather than block
+	// every later flush. Auth failures keep the events queued.
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && rejectedBatch(apiErr) {
+		c.stats.Dropped += len(batch)
+		if c.onFailure != nil {
+			c.onFailure(err, len(batch))
+		}
+		return nil, err
+	}
+	if err == nil {
+		return nil, nil
+	}
+	return batch, err
+}
+
+// rejectedBatch reports whether Langfuse refused a batch as a whole, as
+// opposed to failing temporarily or refusing the credentials.
+func rejectedBatch(err *APIError) bool {
+	return !err.Retryable() && err.StatusCode != http.StatusUnauthorized && err.StatusCode != http.StatusForbidden
+}
+
+// Ingestion request framing around the encoded events.
+const (
+	batchPrefix = `{"batch":[`
+	batchSuffix = `]}`
+)
+
+// encodeBatch builds an ingestion request body from encoded events.
+func encodeBatch(batch [][]byte) []byte {
+	body := []byte(batchPrefix)
+	body = append(body, bytes.Join(batch, []byte(","))...)
+	return append(body, batchSuffix...)
+}
+
+// ackLocked releases the spooled copies of sent events that are no longer
+// queued (must be called with lock held).
+func (c *Client) ackLocked(sent []Event) error {
+	queued := make(map[string]bool, len(c.events))
+	for _, ev := range c.events {
+		queued[ev.ID] = true
+	}
+	for _, ev := range sent {
+		if queued[ev.ID] {
+			continue
+		}
+		delete(c.discarded, ev.ID)
+		if err := c.spool.Ack(ev.ID); err != nil {
+			return fmt.Errorf("failed to update spool: %w", err)
+		}
+	}
+	return nil
+}
+
+// failedLocked reports a failed flush to the failure handler.
+func (c *Client) failedLocked(err error) {
+	if c.onFailure != nil {
+		c.onFailure(err, len(c.events))
+	}
+}
+
+// post sends one ingestion request and returns the decoded response.
+func (c *Client) post(body []byte) (*IngestionResponse, error) {
 	req, err := http.NewRequest("POST", c.baseURL+"/api/public/ingestion", bytes.NewReader(body))
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	req.SetBasicAuth(c.publicKey, c.secretKey)
 
-	// Send request
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
+		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
+	respBody, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode >= 400 {
-		respBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("langfuse API error: status %d, body: %s", resp.StatusCode, string(respBody))
+		return nil, &APIError{
+			StatusCode: resp.StatusCode,
+			Body:       string(respBody),
+			RetryAfter: retryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
 	}
 
-	// Clear events on success
-	c.events = c.events[:0]
-
-	return nil
+	// Older servers answer without per-event results; treat that as success
+	var result IngestionResponse
+	if len(respBody) > 0 {
+		_ = json.Unmarshal(respBody, &result)
+	}
+	return &result, nil
 }
 
-// Shutdown flushes remaining events and closes the client.
-func (c *Client) Shutdown() error {
-	return c.Flush()
+// EventCount returns the number of events queued or waiting to be sent.
+func (c *Client) EventCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.events) + len(c.queue) + c.sending
 }
 
-// EventCount returns the number of pending events.
-func (c *Client) EventCount() int {
+// Delivered reports whether every queued event has been sent or is held in
+// the spool, so that it survives a restart.
+func (c *Client) Delivered() bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	return len(c.events)
+	return c.spool != nil || len(c.events)+len(c.queue)+c.sending == 0
 }
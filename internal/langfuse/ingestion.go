@@ -0,0 +1,174 @@
+package langfuse
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// IngestionResponse is the 207 multi-status body of the ingestion endpoint,
+// with one entry per event of the batch.
+type IngestionResponse struct {
+	Successes []IngestionResult `json:"successes"`
+	Errors    []IngestionResult `json:"errors"`
+}
+
+// IngestionResult is the outcome of one ingestion event.
+type IngestionResult struct {
+	ID      string          `json:"id"`
+	Status  int             `json:"status"`
+	Message string          `json:"message,omitempty"`
+	Error   json.RawMessage `json:"error,omitempty"`
+}
+
+// Rejection describes an event Langfuse refused permanently.
+type Rejection struct {
+	EventID   string
+	EventType string
+	BodyID    string // ID of the trace or observation
+	Status    int
+	Message   string
+}
+
+// PartialError reports events that failed with a retryable status while the
+// rest of their batch was accepted.
+type PartialError struct {
+	Failed  int
+	Status  int    // status of the first failure
+	Message string // message of the first failure
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("%d events failed: status %d: %s", e.Failed, e.Status, e.Message)
+}
+
+// Retryable reports true: only retryable failures produce a PartialError.
+func (e *PartialError) Retryable() bool {
+	return true
+}
+
+// SetRejectionHandler sets a function called for every event Langfuse
+// rejects permanently. fn runs with the client locked and must not call
+// back into it.
+func (c *Client) SetRejectionHandler(fn func(Rejection)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onReject = fn
+}
+
+// SetDeliveryHandler sets a function called with the encoding of every
+// event Langfuse accepts, exactly as it was sent. fn runs on the sender with
+// the client unlocked, so a slow handler never holds up producers, and must
+// not call Flush or Shutdown.
+func (c *Client) SetDeliveryHandler(fn func(line []byte)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onDeliver = fn
+}
+
+// settleLocked removes accepted and permanently rejected events from a
+// batch and their encodings from lines, keeping those that failed with a
+// retryable status. Kept events are counted as requeued once per delivery,
+// however many attempts they fail, and recorded in requeued. It returns the
+// encodings of the accepted events (must be called with lock held).
+func (c *Client) settleLocked(resp *IngestionResponse, batch *[]Event, lines *[][]byte, requeued map[string]bool) ([][]byte, error) {
+	failed := make(map[string]IngestionResult, len(resp.Errors))
+	for _, result := range resp.Errors {
+		failed[result.ID] = result
+	}
+
+	var partial *PartialError
+	var accepted [][]byte
+	var pending []Event
+	var pendingLines [][]byte
+	for i, ev := range *batch {
+		result, ok := failed[ev.ID]
+		if !ok {
+			accepted = append(accepted, (*lines)[i])
+			continue
+		}
+
+		message := result.message()
+		if retryableStatus(result.Status) {
+			pending = append(pending, ev)
+			pendingLines = append(pendingLines, (*lines)[i])
+			if !requeued[ev.ID] {
+				requeued[ev.ID] = true
+				c.stats.Requeued++
+			}
+			if partial == nil {
+				partial = &PartialError{Status: result.Status, Message: message}
+			}
+			partial.Failed++
+			continue
+		}
+
+		c.stats.Rejected++
+		if c.onReject != nil {
+			c.onReject(Rejection{
+				EventID:   ev.ID,
+				EventType: ev.Type,
+				BodyID:    bodyID(ev.Body),
+				Status:    result.Status,
+				Message:   message,
+			})
+		}
+	}
+	*batch, *lines = pending, pendingLines
+
+	if partial != nil {
+		return accepted, partial
+	}
+	return accepted, nil
+}
+
+// notifyDelivered passes accepted events to the delivery handler with the
+// lock released (must be called with lock held).
+func (c *Client) notifyDelivered(lines [][]byte) {
+	fn := c.onDeliver
+	if fn == nil || len(lines) == 0 {
+		return
+	}
+	c.mu.Unlock()
+	defer c.mu.Lock()
+	for _, line := range lines {
+		fn(line)
+	}
+}
+
+// message returns the error text of a result.
+func (r IngestionResult) message() string {
+	if r.Message != "" {
+		return r.Message
+	}
+	if len(r.Error) > 0 {
+		var s string
+		if json.Unmarshal(r.Error, &s) == nil {
+			return s
+		}
+		return string(r.Error)
+	}
+	return http.StatusText(r.Status)
+}
+
+// bodyID returns the ID of the trace or observation an event carries.
+func bodyID(body interface{}) string {
+	switch b := body.(type) {
+	case *Trace:
+		return b.ID
+	case *Generation:
+		return b.ID
+	case *Span:
+		return b.ID
+	case *EventObservation:
+		return b.ID
+	case json.RawMessage:
+		var v struct {
+			ID string `json:"id"`
+		}
+		if json.Unmarshal(b, &v) == nil {
+			return v.ID
+		}
+	}
+	return ""
+}
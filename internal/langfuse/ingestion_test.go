@@ -0,0 +1,146 @@
+package langfuse
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFlush_MultiStatus(t *testing.T) {
+	var batches [][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Batch []struct {
+				ID   string `json:"id"`
+				Body struct {
+					ID string `json:"id"`
+				} `json:"body"`
+			} `json:"batch"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode batch: %v", err)
+		}
+
+		var resp IngestionResponse
+		var ids []string
+		for _, ev := range req.Batch {
+			ids = append(ids, ev.Body.ID)
+			switch {
+			case ev.Body.ID == "trace-bad":
+				resp.Errors = append(resp.Errors, IngestionResult{ID: ev.ID, Status: 400, Message: "Invalid request data"})
+			case ev.Body.ID == "trace-flaky" && len(batches) == 0:
+				resp.Errors = append(resp.Errors, IngestionResult{ID: ev.ID, Status: 500, Error: json.RawMessage(`"database unavailable"`)})
+			default:
+				resp.Successes = append(resp.Successes, IngestionResult{ID: ev.ID, Status: 201})
+			}
+		}
+		batches = append(batches, ids)
+
+		w.WriteHeader(http.StatusMultiStatus)
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "pk", "sk")
+	client.sleep = func(time.Duration) {}
+	var rejections []Rejection
+	client.SetRejectionHandler(func(r Rejection) { rejections = append(rejections, r) })
+	var delivered []string
+	client.SetDeliveryHandler(func(line []byte) {
+		var ev struct {
+			Body struct {
+				ID string `json:"id"`
+			} `json:"body"`
+		}
+		json.Unmarshal(line, &ev)
+		delivered = append(delivered, ev.Body.ID)
+		client.Stats() // the client is not locked
+
+	})
+
+	for _, id := range []string{"trace-ok", "trace-bad", "trace-flaky"} {
+		client.CreateTrace(&Trace{ID: id})
+	}
+	if err := client.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+
+	if len(batches) != 2 || len(batches[1]) != 1 || batches[1][0] != "trace-flaky" {
+		t.Errorf("Expected only the retryable failure to be sent again, got %v", batches)
+	}
+	if client.EventCount() != 0 {
+		t.Errorf("Expected no pending events, got %d", client.EventCount())
+	}
+
+	if len(delivered) != 2 || delivered[0] != "trace-ok" || delivered[1] != "trace-flaky" {
+		t.Errorf("Expected the accepted events to be delivered, got %v", delivered)
+	}
+
+	if len(rejections) != 1 {
+		t.Fatalf("Expected 1 rejection, got %+v", rejections)
+	}
+	r := rejections[0]
+	if r.BodyID != "trace-bad" || r.EventType != EventTraceCreate || r.Status != 400 || r.Message != "Invalid request data" || r.EventID == "" {
+		t.Errorf("Unexpected rejection: %+v", r)
+	}
+
+	if stats := client.Stats(); stats.Rejected != 1 || stats.Requeued != 1 || stats.Retries != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestFlush_RequeuedOncePerFlush(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Batch []struct {
+				ID string `json:"id"`
+			} `json:"batch"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		attempts++
+
+		// The event fails three attempts before it is accepted
+		var resp IngestionResponse
+		for _, ev := range req.Batch {
+			if attempts <= 3 {
+				resp.Errors = append(resp.Errors, IngestionResult{ID: ev.ID, Status: 503})
+			} else {
+				resp.Successes = append(resp.Successes, IngestionResult{ID: ev.ID, Status: 201})
+			}
+		}
+		w.WriteHeader(http.StatusMultiStatus)
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "pk", "sk")
+	client.sleep = func(time.Duration) {}
+	client.CreateTrace(&Trace{ID: "trace-flaky"})
+	if err := client.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+
+	if stats := client.Stats(); attempts != 4 || stats.Retries != 3 || stats.Requeued != 1 {
+		t.Errorf("Expected one requeued event over %d attempts, got %+v", attempts, stats)
+	}
+}
+
+func TestIngestionResult_Message(t *testing.T) {
+	tests := []struct {
+		result   IngestionResult
+		expected string
+	}{
+		{IngestionResult{Status: 400, Message: "bad"}, "bad"},
+		{IngestionResult{Status: 500, Error: json.RawMessage(`"boom"`)}, "boom"},
+		{IngestionResult{Status: 400, Error: json.RawMessage(`{"issues":[]}`)}, `{"issues":[]}`},
+		{IngestionResult{Status: 409}, "Conflict"},
+	}
+	for _, tc := range tests {
+		if got := tc.result.message(); got != tc.expected {
+			t.Errorf("message() = %q, expected %q", got, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package langfuse
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// mediaUploadRequest asks Langfuse for a presigned upload URL.
+type mediaUploadRequest struct {
+	TraceID       string `json:"traceId"`
+	ObservationID string `json:"observationId,omitempty"`
+	ContentType   string `json:"contentType"`
+	ContentLength int    `json:"contentLength"`
+	SHA256Hash    string `json:"sha256Hash"`
+	Field         string `json:"field"`
+}
+
+// mediaUploadResponse is returned by the media endpoint. UploadURL is empty
+// when Langfuse already stores media with the same hash.
+type mediaUploadResponse struct {
+	UploadURL string `json:"uploadUrl"`
+	MediaID   string `json:"mediaId"`
+}
+
+// mediaUploadStatus reports the result of the presigned upload.
+type mediaUploadStatus struct {
+	UploadedAt       string `json:"uploadedAt"`
+	UploadHTTPStatus int    `json:"uploadHttpStatus"`
+	UploadHTTPError  string `json:"uploadHttpError,omitempty"`
+	UploadTimeMs     int64  `json:"uploadTimeMs"`
+}
+
+// MediaReference returns the token Langfuse replaces with the uploaded media
+// when rendering a trace or observation field.
+func MediaReference(contentType, mediaID string) string {
+	return fmt.Sprintf("@@@langfuseMedia:type=%s|id=%s|source=base64_data_uri@@@", contentType, mediaID)
+}
+
+// UploadMedia uploads binary content attached to a trace field (e.g.
+// "input") and returns its media ID. ctx bounds the whole upload.
+func (c *Client) UploadMedia(ctx context.Context, traceID, field, contentType string, data []byte) (string, error) {
+	hash := sha256.Sum256(data)
+	checksum := base64.StdEncoding.EncodeToString(hash[:])
+
+	reqBody, err := json.Marshal(mediaUploadRequest{
+		TraceID:       traceID,
+		ContentType:   contentType,
+		ContentLength: len(data),
+		SHA256Hash:    checksum,
+		Field:         field,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal media request: %w", err)
+	}
+
+	var upload mediaUploadResponse
+	if err := c.doJSON(ctx, "POST", "/api/public/media", reqBody, &upload); err != nil {
+		return "", err
+	}
+
+	// Already uploaded with the same content
+	if upload.UploadURL == "" {
+		return upload.MediaID, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", upload.UploadURL, bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("failed to create upload request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Amz-Checksum-Sha256", checksum)
+
+	start := time.Now()
+	status := mediaUploadStatus{}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		status.UploadHTTPError = err.Error()
+	} else {
+		status.UploadHTTPStatus = resp.StatusCode
+		if resp.StatusCode >= 400 {
+			respBody, _ := io.ReadAll(resp.Body)
+			status.UploadHTTPError = string(respBody)
+		}
+		resp.Body.Close()
+	}
+	status.UploadTimeMs = time.Since(start).Milliseconds()
+	status.UploadedAt = time.Now().UTC().Format(time.RFC3339Nano)
+
+	statusBody, err := json.Marshal(status)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal upload status: %w", err)
+	}
+	if err := c.doJSON(ctx, "PATCH", "/api/public/media/"+upload.MediaID, statusBody, nil); err != nil {
+		return "", err
+	}
+
+	if status.UploadHTTPError != "" {
+		return "", fmt.Errorf("media upload failed: status %d: %s", status.UploadHTTPStatus, status.UploadHTTPError)
+	}
+
+	return upload.MediaID, nil
+}
+
+// doJSON sends an authenticated JSON request to the Langfuse API and decodes
+// the response into out if it is non-nil.
+func (c *Client) doJSON(ctx context.Context, method, path string, body []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(c.publicKey, c.secretKey)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("langfuse API error: status %d, body: %s", resp.StatusCode, string(respBody))
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+
+	return nil
+}
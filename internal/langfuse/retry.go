@@ -0,0 +1,160 @@
+package langfuse
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how failed ingestion requests are retried.
+type RetryPolicy struct {
+	MaxAttempts int           // attempts per flush, including the first
+	BaseDelay   time.Duration // delay before the first retry, doubled each time
+	MaxDelay    time.Duration // cap on a single delay, including Retry-After
+}
+
+// DefaultRetryPolicy retries up to four times over roughly eight seconds.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+}
+
+// APIError is an error response from the Langfuse API.
+type APIError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration // from the Retry-After header, if any
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("langfuse API error: status %d, body: %s", e.StatusCode, e.Body)
+}
+
+// Retryable reports whether the request may succeed if sent again: rate
+// limits, timeouts and server errors are retried, other client errors such
+// as 400, 401 and 403 are not.
+func (e *APIError) Retryable() bool {
+	return retryableStatus(e.StatusCode)
+}
+
+// DeliveryStats counts the outcome of ingestion requests.
+type DeliveryStats struct {
+	Retries   int // requests sent again after a failure
+	Exhausted int // flushes that failed after every attempt
+	Dropped   int // events discarded without being delivered
+	Requeued  int // events that failed individually and were retried, once per flush
+	Rejected  int // events Langfuse rejected individually
+	Truncated int // events shortened to fit the batch size limit
+}
+
+// SetRetryPolicy sets how failed requests are retried.
+func (c *Client) SetRetryPolicy(p RetryPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p.MaxAttempts < 1 {
+		p.MaxAttempts = 1
+	}
+	c.retry = p
+}
+
+// SetFailureHandler sets a function called with the error and number of
+// events whenever a flush gives up, either because retries were exhausted
+// (the events stay queued for the next flush) or because Langfuse rejected
+// the batch (the events are dropped), and when a full spool or queue drops
+// events. fn runs with the client locked and must not call back into it.
+func (c *Client) SetFailureHandler(fn func(err error, events int)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onFailure = fn
+}
+
+// Stats returns the delivery counters.
+func (c *Client) Stats() DeliveryStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// withRetry calls send until it succeeds, fails permanently or runs out of
+// attempts, sleeping with exponential backoff and jitter in between (must
+// be called with lock held; it is released while sleeping).
+func (c *Client) withRetry(send func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = send(); err == nil {
+			return nil
+		}
+
+		if !retryable(err) {
+			return err
+		}
+		if attempt >= c.retry.MaxAttempts {
+			c.stats.Exhausted++
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+
+		delay := c.backoff(attempt)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			delay = apiErr.RetryAfter
+			if c.retry.MaxDelay > 0 && delay > c.retry.MaxDelay {
+				delay = c.retry.MaxDelay
+			}
+		}
+		c.stats.Retries++
+		c.mu.Unlock()
+		c.sleep(delay)
+		c.mu.Lock()
+	}
+}
+
+// retryable reports whether a failed request may succeed if sent again:
+// transport failures, and errors that say so.
+func retryable(err error) bool {
+	var r interface{ Retryable() bool }
+	if errors.As(err, &r) {
+		return r.Retryable()
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// retryableStatus reports whether a request or event that failed with
+// status may succeed later: rate limits, timeouts and server errors.
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusRequestTimeout || status >= 500
+}
+
+// backoff returns the delay before retry n: the base delay doubled for each
+// earlier retry, capped, with the upper half randomised so that clients do
+// not retry in lockstep.
+func (c *Client) backoff(n int) time.Duration {
+	d := c.retry.BaseDelay << (n - 1)
+	if d <= 0 || (c.retry.MaxDelay > 0 && d > c.retry.MaxDelay) {
+		d = c.retry.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// retryAfter parses a Retry-After header given in seconds or as a date.
+func retryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(header); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(header); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
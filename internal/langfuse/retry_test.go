@@ -0,0 +1,130 @@
+package langfuse
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client for a server that answers with the given
+// statuses in turn (then 200), and records the delays it sleeps.
+func newTestClient(t *testing.T, statuses ...int) (*Client, *[]time.Duration, *int) {
+	t.Helper()
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if len(statuses) == 0 {
+			return
+		}
+		status := statuses[0]
+		statuses = statuses[1:]
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "3")
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	var sleeps []time.Duration
+	client := NewClient(server.URL, "pk", "sk")
+	client.sleep = func(d time.Duration) { sleeps = append(sleeps, d) }
+	return client, &sleeps, &requests
+}
+
+func TestFlush_RetriesWithBackoff(t *testing.T) {
+	client, sleeps, requests := newTestClient(t, http.StatusServiceUnavailable, http.StatusBadGateway)
+
+	client.CreateTrace(&Trace{ID: "trace-1"})
+	if err := client.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+
+	if *requests != 3 || client.EventCount() != 0 {
+		t.Errorf("Expected success on the third attempt, got %d requests and %d pending", *requests, client.EventCount())
+	}
+	if len(*sleeps) != 2 {
+		t.Fatalf("Expected 2 backoff delays, got %v", *sleeps)
+	}
+	for i, d := range *sleeps {
+		base := DefaultRetryPolicy.BaseDelay << i
+		if d < base/2 || d > base {
+			t.Errorf("Expected delay %d between %v and %v, got %v", i, base/2, base, d)
+		}
+	}
+	if stats := client.Stats(); stats.Retries != 2 || stats.Exhausted != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestFlush_HonoursRetryAfter(t *testing.T) {
+	client, sleeps, _ := newTestClient(t, http.StatusTooManyRequests)
+
+	client.CreateTrace(&Trace{ID: "trace-1"})
+	if err := client.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	if len(*sleeps) != 1 || (*sleeps)[0] != 3*time.Second {
+		t.Errorf("Expected to wait 3s as asked, got %v", *sleeps)
+	}
+}
+
+func TestFlush_NonRetryable(t *testing.T) {
+	tests := []struct {
+		status  int
+		pending int
+	}{
+		{http.StatusBadRequest, 0},   // rejected batch is dropped
+		{http.StatusUnauthorized, 1}, // kept until credentials work
+		{http.StatusForbidden, 1},
+	}
+
+	for _, tc := range tests {
+		client, sleeps, requests := newTestClient(t, tc.status)
+		var reported int
+		client.SetFailureHandler(func(err error, events int) { reported = events })
+
+		client.CreateTrace(&Trace{ID: "trace-1"})
+		if err := client.Flush(); err == nil {
+			t.Errorf("Expected error for status %d", tc.status)
+		}
+		if *requests != 1 || len(*sleeps) != 0 {
+			t.Errorf("Expected no retry for status %d, got %d requests", tc.status, *requests)
+		}
+		if client.EventCount() != tc.pending || reported != 1 {
+			t.Errorf("Status %d: expected %d pending and 1 reported, got %d and %d", tc.status, tc.pending, client.EventCount(), reported)
+		}
+	}
+}
+
+func TestFlush_ExhaustedRetries(t *testing.T) {
+	client, _, requests := newTestClient(t, 500, 500, 500, 500, 500, 500)
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+
+	var failure error
+	client.SetFailureHandler(func(err error, events int) { failure = err })
+
+	client.CreateTrace(&Trace{ID: "trace-1"})
+	if err := client.Flush(); err == nil || failure == nil || !strings.Contains(failure.Error(), "after 3 attempts") {
+		t.Errorf("Expected exhausted retries to be reported, got %v", failure)
+	}
+	if *requests != 3 || client.EventCount() != 1 || client.Stats().Exhausted != 1 {
+		t.Errorf("Expected 3 attempts with the event kept, got %d requests and %d pending", *requests, client.EventCount())
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := map[string]time.Duration{
+		"":                              0,
+		"7":                             7 * time.Second,
+		"soon":                          0,
+		"Mon, 01 Jan 2024 00:00:10 GMT": 10 * time.Second,
+	}
+	for header, expected := range tests {
+		if got := retryAfter(header, now); got != expected {
+			t.Errorf("retryAfter(%q) = %v, expected %v", header, got, expected)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package langfuse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultFlushInterval is how often queued events are sent when no batch
+// fills up in the meantime.
+const DefaultFlushInterval = 5 * time.Second
+
+// DefaultQueueSize is the default number of events that can wait for the
+// sender before backpressure applies.
+const DefaultQueueSize = 10000
+
+// Backpressure policies, applied when the queue to the sender is full.
+const (
+	BackpressureBlock = "block" // wait for room
+	BackpressureDrop  = "drop"  // discard the new event
+)
+
+// ErrQueueFull is returned when an event is dropped because the queue is
+// full and the backpressure policy is to drop.
+var ErrQueueFull = errors.New("queue is full")
+
+// ErrClosed is returned when an event is queued after Shutdown.
+var ErrClosed = errors.New("client is shut down")
+
+// queuedEvent is an event on its way to the sender, with its encoded size.
+type queuedEvent struct {
+	ev   Event
+	size int
+}
+
+// SetFlushInterval sets how often queued events are sent. It must be called
+// before the first event is queued.
+func (c *Client) SetFlushInterval(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d > 0 {
+		c.flushInterval = d
+	}
+}
+
+// SetQueueSize sets how many events can wait for the sender. It must be
+// called before the first event is queued.
+func (c *Client) SetQueueSize(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n > 0 {
+		c.queueSize = n
+	}
+}
+
+// SetBackpressure sets what happens to new events when the queue is full:
+// BackpressureBlock makes the caller wait and BackpressureDrop discards them.
+func (c *Client) SetBackpressure(policy string) error {
+	switch policy {
+	case "":
+		policy = BackpressureBlock
+	case BackpressureBlock, BackpressureDrop:
+	default:
+		return fmt.Errorf("unknown backpressure policy %q", policy)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.backpressure = policy
+	return nil
+}
+
+// start launches the sender goroutine on first use.
+func (c *Client) start() {
+	c.startOnce.Do(func() {
+		c.mu.Lock()
+		c.queue = make(chan queuedEvent, c.queueSize)
+		interval := c.flushInterval
+		c.mu.Unlock()
+		go c.run(interval)
+	})
+}
+
+// send hands an event to the sender, applying the backpressure policy when
+// the queue is full. Callers hold enqueueMu, so only the sender takes from
+// the queue while it runs. A caller blocked on a full queue gives up with
+// ErrClosed once Shutdown begins; the event stays in the spool, if any.
+func (c *Client) send(qe queuedEvent) error {
+	c.mu.Lock()
+	drop := c.backpressure == BackpressureDrop && len(c.queue) == cap(c.queue)
+	c.mu.Unlock()
+	if !drop {
+		select {
+		case c.queue <- qe:
+			return nil
+		case <-c.stop:
+			return ErrClosed
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Dropped++
+	if c.onFailure != nil {
+		c.onFailure(ErrQueueFull, 1)
+	}
+	if c.spool != nil {
+		if err := c.spool.Ack(qe.ev.ID); err != nil {
+			return fmt.Errorf("failed to update spool: %w", err)
+		}
+	}
+	return ErrQueueFull
+}
+
+// run is the sender goroutine. It moves events from the queue to the
+// pending batch and sends them when a batch fills up, every interval, on
+// Flush and on Shutdown.
+func (c *Client) run(interval time.Duration) {
+	defer close(c.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// Without a spool, stop taking events while a backlog as large as
+		// the queue is waiting, so that callers feel the backpressure
+		queue := c.queue
+		c.mu.Lock()
+		if c.spool == nil && len(c.events) >= c.queueSize {
+			queue = nil
+		}
+		c.mu.Unlock()
+
+		select {
+		case qe := <-queue:
+			c.mu.Lock()
+			c.addLocked(qe)
+			if len(c.events) >= c.batchSize || c.pendingBytes >= c.maxBatchBytes {
+				c.flushLocked()
+			}
+			c.mu.Unlock()
+
+		case <-ticker.C:
+			c.mu.Lock()
+			c.flushLocked()
+			c.mu.Unlock()
+
+		case reply := <-c.flushReq:
+			c.mu.Lock()
+			c.drainLocked()
+			reply <- c.flushLocked()
+			c.mu.Unlock()
+
+		case <-c.stop:
+			// Wait for the producer in send, if any, to queue its event or
+			// give up, so that nothing is queued after the final drain
+			c.enqueueMu.Lock()
+			c.enqueueMu.Unlock()
+
+			c.mu.Lock()
+			c.drainLocked()
+			err := c.flushLocked()
+			if c.spool != nil {
+				if closeErr := c.spool.Close(); closeErr != nil && err == nil {
+					err = closeErr
+				}
+			}
+			c.shutdownErr = err
+			c.mu.Unlock()
+			return
+		}
+	}
+}
+
+// addLocked appends an event to the pending batch (must be called with lock
+// held).
+func (c *Client) addLocked(qe queuedEvent) {
+	c.events = append(c.events, qe.ev)
+	c.pendingBytes += qe.size
+}
+
+// drainLocked moves every event waiting in the queue to the pending batch
+// (must be called with lock held).
+func (c *Client) drainLocked() {
+	for {
+		select {
+		case qe := <-c.queue:
+			c.addLocked(qe)
+		default:
+			return
+		}
+	}
+}
+
+// Flush sends all queued events to Langfuse and waits for the result.
+func (c *Client) Flush() error {
+	c.start()
+	reply := make(chan error, 1)
+	select {
+	case c.flushReq <- reply:
+		return <-reply
+	case <-c.stopped:
+		return ErrClosed
+	}
+}
+
+// Shutdown stops accepting events, sends the remaining ones and closes the
+// client. If ctx expires first it returns the context error; events that
+// could not be sent stay in the spool, if any, for the next run. It does not
+// wait for producers, so a caller blocked on a full queue cannot hold it up.
+func (c *Client) Shutdown(ctx context.Context) error {
+	c.start()
+	c.stopOnce.Do(func() { close(c.stop) })
+
+	select {
+	case <-c.stopped:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.shutdownErr
+	case <-ctx.Done():
+		return fmt.Errorf("events still pending at shutdown: %w", ctx.Err())
+	}
+}
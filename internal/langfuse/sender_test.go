@@ -0,0 +1,181 @@
+package langfuse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowServer answers ingestion requests only once release is closed, and
+// signals each request it receives on started.
+func slowServer(t *testing.T) (server *httptest.Server, started chan struct{}, release chan struct{}, received func() int) {
+	t.Helper()
+	started = make(chan struct{}, 100)
+	release = make(chan struct{})
+	var mu sync.Mutex
+	count := 0
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		var req struct {
+			Batch []json.RawMessage `json:"batch"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		count += len(req.Batch)
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	return server, started, release, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return count
+	}
+}
+
+func TestEnqueue_DoesNotWaitForRequests(t *testing.T) {
+	server, started, release, received := slowServer(t)
+	client := NewClient(server.URL, "pk", "sk")
+	client.SetBatchSize(1)
+
+	client.CreateTrace(&Trace{ID: "trace-1"})
+	<-started
+
+	// The sender is stuck in a request; callers carry on queuing
+	begin := time.Now()
+	for i := 0; i < 50; i++ {
+		if err := client.CreateTrace(&Trace{ID: "trace"}); err != nil {
+			t.Fatalf("CreateTrace() failed: %v", err)
+		}
+	}
+	if elapsed := time.Since(begin); elapsed > time.Second {
+		t.Errorf("Expected queuing not to wait for the request, took %v", elapsed)
+	}
+
+	close(release)
+	if err := client.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() failed: %v", err)
+	}
+	if received() != 51 {
+		t.Errorf("Expected every event sent by shutdown, got %d", received())
+	}
+	if err := client.CreateTrace(&Trace{ID: "late"}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed after shutdown, got %v", err)
+	}
+}
+
+func TestEnqueue_BackpressureDrop(t *testing.T) {
+	server, started, release, _ := slowServer(t)
+	defer close(release)
+	client := NewClient(server.URL, "pk", "sk")
+	client.SetBatchSize(1)
+	client.SetQueueSize(1)
+	if err := client.SetBackpressure(BackpressureDrop); err != nil {
+		t.Fatalf("SetBackpressure() failed: %v", err)
+	}
+	failures := 0
+	client.SetFailureHandler(func(err error, events int) { failures++ })
+
+	client.CreateTrace(&Trace{ID: "trace-1"})
+	<-started
+	if err := client.CreateTrace(&Trace{ID: "trace-2"}); err != nil {
+		t.Fatalf("Expected room for one waiting event, got %v", err)
+	}
+	if err := client.CreateTrace(&Trace{ID: "trace-3"}); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Expected ErrQueueFull, got %v", err)
+	}
+	if client.Stats().Dropped != 1 || failures != 1 {
+		t.Errorf("Expected the dropped event to be counted and reported, got %+v and %d failures", client.Stats(), failures)
+	}
+
+	if err := client.SetBackpressure("sideways"); err == nil {
+		t.Error("Expected error for unknown backpressure policy")
+	}
+}
+
+func TestEnqueue_BackpressureBlock(t *testing.T) {
+	server, started, release, received := slowServer(t)
+	client := NewClient(server.URL, "pk", "sk")
+	client.SetBatchSize(1)
+	client.SetQueueSize(1)
+
+	client.CreateTrace(&Trace{ID: "trace-1"})
+	<-started
+	client.CreateTrace(&Trace{ID: "trace-2"})
+
+	done := make(chan error, 1)
+	go func() { done <- client.CreateTrace(&Trace{ID: "trace-3"}) }()
+	select {
+	case err := <-done:
+		t.Fatalf("Expected CreateTrace() to wait while the queue is full, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("CreateTrace() failed: %v", err)
+	}
+	if err := client.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() failed: %v", err)
+	}
+	if received() != 3 {
+		t.Errorf("Expected 3 events sent, got %d", received())
+	}
+}
+
+func TestShutdown_Deadline(t *testing.T) {
+	server, started, release, _ := slowServer(t)
+	defer close(release)
+	client := NewClient(server.URL, "pk", "sk")
+
+	client.CreateTrace(&Trace{ID: "trace-1"})
+	go client.Flush()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := client.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline error, got %v", err)
+	}
+}
+
+func TestShutdown_BlockedProducer(t *testing.T) {
+	server, started, release, _ := slowServer(t)
+	defer close(release)
+	client := NewClient(server.URL, "pk", "sk")
+	client.SetBatchSize(1)
+	client.SetQueueSize(1)
+
+	client.CreateTrace(&Trace{ID: "trace-1"})
+	<-started
+	client.CreateTrace(&Trace{ID: "trace-2"})
+
+	// A producer waits for room in the full queue
+	done := make(chan error, 1)
+	go func() { done <- client.CreateTrace(&Trace{ID: "trace-3"}) }()
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	if err := client.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline error, got %v", err)
+	}
+	if elapsed := time.Since(begin); elapsed > time.Second {
+		t.Errorf("Expected Shutdown() to return at its deadline, took %v", elapsed)
+	}
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("Expected blocked producer to give up with ErrClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected blocked producer to be released by Shutdown()")
+	}
+}
@@ -0,0 +1,339 @@
+package langfuse
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultSpoolMaxBytes is the default cap on the size of a spool.
+const DefaultSpoolMaxBytes = 100 * 1024 * 1024
+
+// Spool drop policies, applied when an event would take the spool past its
+// size cap.
+const (
+	DropOldest = "oldest" // discard the oldest segment to make room
+	DropNewest = "newest" // refuse the new event
+)
+
+// segmentMaxBytes is the size at which the active segment is closed and a new
+// one started, so that drained segments can be deleted as a whole. Smaller
+// spools use an eighth of their cap, so that dropping the oldest segment
+// never discards most of the spool.
+const segmentMaxBytes = 4 * 1024 * 1024
+
+// ErrSpoolFull is returned when an event does not fit in the spool.
+var ErrSpoolFull = errors.New("spool is full")
+
+// Spool is an on-disk write-ahead log of queued events. Events are appended
+// to numbered segment files before they are acknowledged to the caller, and
+// a segment is deleted once every event in it has been delivered or dropped.
+// A Spool is not safe for concurrent use; the client serialises access.
+type Spool struct {
+	dir          string
+	maxBytes     int64
+	segmentBytes int64
+	policy       string
+
+	segments []*segment          // oldest first
+	index    map[string]*segment // pending event ID -> segment
+	active   *os.File            // segment appended to, if open
+	size     int64
+}
+
+// segment is one spool file.
+type segment struct {
+	seq     int
+	size    int64
+	ids     []string
+	pending int
+}
+
+// OpenSpool opens the spool in dir and returns the events left in it by a
+// previous run, oldest first.
+func OpenSpool(dir string, maxBytes int64, policy string) (*Spool, []Event, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultSpoolMaxBytes
+	}
+	switch policy {
+	case "":
+		policy = DropOldest
+	case DropOldest, DropNewest:
+	default:
+		return nil, nil, fmt.Errorf("unknown spool drop policy %q", policy)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, nil, err
+	}
+
+	segmentBytes := int64(segmentMaxBytes)
+	if maxBytes/8 < segmentBytes {
+		segmentBytes = maxBytes / 8
+	}
+
+	s := &Spool{
+		dir:          dir,
+		maxBytes:     maxBytes,
+		segmentBytes: segmentBytes,
+		policy:       policy,
+		index:        make(map[string]*segment),
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	var seqs []int
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".ndjson")
+		if seq, err := strconv.Atoi(name); ok && err == nil && !entry.IsDir() {
+			seqs = append(seqs, seq)
+		}
+	}
+	sort.Ints(seqs)
+
+	var events []Event
+	for _, seq := range seqs {
+		seg := &segment{seq: seq}
+		loaded, size, err := readSegment(s.path(seq))
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, ev := range loaded {
+			if _, dup := s.index[ev.ID]; dup {
+				continue
+			}
+			seg.ids = append(seg.ids, ev.ID)
+			seg.pending++
+			s.index[ev.ID] = seg
+			events = append(events, ev)
+		}
+		if seg.pending == 0 {
+			os.Remove(s.path(seq))
+			continue
+		}
+		seg.size = size
+		s.size += size
+		s.segments = append(s.segments, seg)
+	}
+	return s, events, nil
+}
+
+// readSegment returns the events in a segment file and its size. A torn
+// last line, left by a crash mid-write, is skipped.
+func readSegment(path string) ([]Event, int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var events []Event
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		var ev struct {
+			Event
+			Body json.RawMessage `json:"body"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil || ev.ID == "" {
+			continue
+		}
+		ev.Event.Body = ev.Body
+		events = append(events, ev.Event)
+	}
+	return events, int64(len(data)), scanner.Err()
+}
+
+// Append writes an event to the spool. With the oldest-first drop policy it
+// returns the IDs of pending events discarded to make room; with the newest
+// policy it returns ErrSpoolFull instead.
+func (s *Spool) Append(ev Event) ([]string, error) {
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event: %w", err)
+	}
+	line = append(line, '\n')
+	n := int64(len(line))
+
+	var dropped []string
+	for s.size+n > s.maxBytes {
+		if s.policy == DropNewest || len(s.segments) == 0 {
+			return dropped, ErrSpoolFull
+		}
+		ids, err := s.dropOldest()
+		dropped = append(dropped, ids...)
+		if err != nil {
+			return dropped, err
+		}
+	}
+
+	seg, err := s.activeSegment(n)
+	if err != nil {
+		return dropped, err
+	}
+	if _, err := s.active.Write(line); err != nil {
+		return dropped, fmt.Errorf("failed to write spool: %w", err)
+	}
+	seg.size += n
+	seg.ids = append(seg.ids, ev.ID)
+	seg.pending++
+	s.size += n
+	s.index[ev.ID] = seg
+	return dropped, nil
+}
+
+// activeSegment returns the segment to append n bytes to, starting a new one
+// when there is none open or the current one is full.
+func (s *Spool) activeSegment(n int64) (*segment, error) {
+	if s.active != nil {
+		seg := s.segments[len(s.segments)-1]
+		if seg.size+n <= s.segmentBytes || seg.size == 0 {
+			return seg, nil
+		}
+		if err := s.closeActive(); err != nil {
+			return nil, err
+		}
+	}
+
+	seq := 1
+	if len(s.segments) > 0 {
+		seq = s.segments[len(s.segments)-1].seq + 1
+	}
+	f, err := os.OpenFile(s.path(seq), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	seg := &segment{seq: seq}
+	s.segments = append(s.segments, seg)
+	s.active = f
+	return seg, nil
+}
+
+// dropOldest deletes the oldest segment and returns its pending event IDs.
+func (s *Spool) dropOldest() ([]string, error) {
+	seg := s.segments[0]
+	var ids []string
+	for _, id := range seg.ids {
+		if s.index[id] == seg {
+			delete(s.index, id)
+			ids = append(ids, id)
+		}
+	}
+	return ids, s.remove(seg)
+}
+
+// Ack marks an event as delivered or discarded, deleting its segment once
+// nothing in it is pending.
+func (s *Spool) Ack(id string) error {
+	seg, ok := s.index[id]
+	if !ok {
+		return nil
+	}
+	delete(s.index, id)
+	seg.pending--
+	if seg.pending > 0 {
+		return nil
+	}
+	return s.remove(seg)
+}
+
+// remove deletes a segment, closing it first if it is the active one.
+func (s *Spool) remove(seg *segment) error {
+	for i, other := range s.segments {
+		if other != seg {
+			continue
+		}
+		if i == len(s.segments)-1 && s.active != nil {
+			if err := s.closeActive(); err != nil {
+				return err
+			}
+		}
+		s.segments = append(s.segments[:i], s.segments[i+1:]...)
+		s.size -= seg.size
+		break
+	}
+	if err := os.Remove(s.path(seg.seq)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Sync commits appended events to stable storage.
+func (s *Spool) Sync() error {
+	if s.active == nil {
+		return nil
+	}
+	return s.active.Sync()
+}
+
+// Close syncs and closes the active segment. Pending events stay on disk
+// for the next run.
+func (s *Spool) Close() error {
+	return s.closeActive()
+}
+
+// closeActive syncs and closes the active segment.
+func (s *Spool) closeActive() error {
+	if s.active == nil {
+		return nil
+	}
+	f := s.active
+	s.active = nil
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Size returns the number of bytes in the spool.
+func (s *Spool) Size() int64 {
+	return s.size
+}
+
+// path returns the file name of a segment.
+func (s *Spool) path(seq int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%012d.ndjson", seq))
+}
+
+// OpenSpool makes the client persist queued events in a spool in dir, so
+// that they survive restarts and outages, and queues the events left there
+// by a previous run ahead of new ones. It returns the number restored. It
+// must be called before the first event is queued.
+func (c *Client) OpenSpool(dir string, maxBytes int64, policy string) (int, error) {
+	s, restored, err := OpenSpool(dir, maxBytes, policy)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.spool = s
+	c.events = append(restored, c.events...)
+	for _, ev := range restored {
+		c.pendingBytes += encodedSize(ev)
+	}
+	return len(restored), nil
+}
+
+// discardLocked marks events dropped from the spool so that the sender
+// skips them (must be called with lock held).
+func (c *Client) discardLocked(ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+	for _, id := range ids {
+		c.discarded[id] = true
+	}
+	c.stats.Dropped += len(ids)
+	if c.onFailure != nil {
+		c.onFailure(fmt.Errorf("spool is full, dropped the oldest events"), len(ids))
+	}
+}
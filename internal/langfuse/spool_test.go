@@ -0,0 +1,177 @@
+package langfuse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// segmentFiles returns the segment files in a spool directory.
+func segmentFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "*.ndjson"))
+	if err != nil {
+		t.Fatalf("Glob failed: %v", err)
+	}
+	return files
+}
+
+func TestSpool_SurvivesOutageAndRestart(t *testing.T) {
+	dir := t.TempDir()
+	available := false
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		var req struct {
+			Batch []struct {
+				Body struct {
+					ID string `json:"id"`
+				} `json:"body"`
+			} `json:"batch"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		for _, ev := range req.Batch {
+			received = append(received, ev.Body.ID)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "pk", "sk")
+	client.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+	failures := 0
+	client.SetFailureHandler(func(err error, events int) { failures++ })
+	if _, err := client.OpenSpool(dir, 0, ""); err != nil {
+		t.Fatalf("OpenSpool() failed: %v", err)
+	}
+
+	for _, id := range []string{"trace-1", "trace-2", "trace-3"} {
+		if err := client.CreateTrace(&Trace{ID: id}); err != nil {
+			t.Fatalf("CreateTrace() failed: %v", err)
+		}
+	}
+	// Delivery fails, but the events are safe on disk
+	if err := client.Shutdown(context.Background()); err != nil {
+		t.Errorf("Expected spooled flush to succeed, got %v", err)
+	}
+	if failures != 1 || len(segmentFiles(t, dir)) == 0 {
+		t.Fatalf("Expected failure to be reported and events kept on disk, got %d failures", failures)
+	}
+
+	// A new process picks the events up and drains them in order
+	available = true
+	restarted := NewClient(server.URL, "pk", "sk")
+	restored, err := restarted.OpenSpool(dir, 0, "")
+	if err != nil {
+		t.Fatalf("OpenSpool() failed: %v", err)
+	}
+	if restored != 3 {
+		t.Errorf("Expected 3 restored events, got %d", restored)
+	}
+	restarted.CreateTrace(&Trace{ID: "trace-4"})
+	if err := restarted.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+
+	if strings.Join(received, ",") != "trace-1,trace-2,trace-3,trace-4" {
+		t.Errorf("Expected spooled events first and in order, got %v", received)
+	}
+	if files := segmentFiles(t, dir); len(files) != 0 {
+		t.Errorf("Expected drained spool to be empty, got %v", files)
+	}
+}
+
+func TestSpool_DropOldest(t *testing.T) {
+	const maxBytes = 64 * 1024
+	s, _, err := OpenSpool(t.TempDir(), maxBytes, DropOldest)
+	if err != nil {
+		t.Fatalf("OpenSpool() failed: %v", err)
+	}
+
+	var ids, dropped []string
+	for i := 0; i < 2000; i++ {
+		ev := NewEvent(EventTraceCreate, &Trace{ID: fmt.Sprintf("trace-%04d", i)})
+		ids = append(ids, ev.ID)
+		lost, err := s.Append(ev)
+		if err != nil {
+			t.Fatalf("Append() failed: %v", err)
+		}
+		dropped = append(dropped, lost...)
+	}
+
+	if len(dropped) == 0 {
+		t.Fatal("Expected old events to be dropped")
+	}
+	if s.Size() > maxBytes || s.Size() < maxBytes*3/4 {
+		t.Errorf("Expected the spool to stay close to its cap, got %d bytes", s.Size())
+	}
+	if len(s.index)+len(dropped) != len(ids) {
+		t.Errorf("Expected every event kept or dropped, got %d kept and %d dropped", len(s.index), len(dropped))
+	}
+	for i, id := range dropped {
+		if id != ids[i] {
+			t.Fatalf("Expected only the oldest events dropped, got %s at %d", id, i)
+		}
+	}
+}
+
+func TestSpool_DropNewest(t *testing.T) {
+	client := NewClient("http://localhost", "pk", "sk")
+	if _, err := client.OpenSpool(t.TempDir(), 300, DropNewest); err != nil {
+		t.Fatalf("OpenSpool() failed: %v", err)
+	}
+
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		err = client.CreateTrace(&Trace{ID: "trace"})
+	}
+	if !errors.Is(err, ErrSpoolFull) {
+		t.Fatalf("Expected ErrSpoolFull, got %v", err)
+	}
+	if client.EventCount() == 0 || client.Stats().Dropped != 1 {
+		t.Errorf("Expected earlier events kept and the new one dropped, got %d pending, %+v", client.EventCount(), client.Stats())
+	}
+}
+
+func TestSpool_TornWrite(t *testing.T) {
+	dir := t.TempDir()
+	data := `{"id":"ev-1","timestamp":"2024-01-01T00:00:00Z","type":"trace-create","body":{"id":"trace-1"}}` + "\n" +
+		`{"id":"ev-2","timestamp":"2024-01-01T00:00:00Z","type":"tra`
+	if err := os.WriteFile(filepath.Join(dir, "000000000001.ndjson"), []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, events, err := OpenSpool(dir, 0, "")
+	if err != nil {
+		t.Fatalf("OpenSpool() failed: %v", err)
+	}
+	if len(events) != 1 || events[0].ID != "ev-1" || bodyID(events[0].Body) != "trace-1" {
+		t.Fatalf("Expected the complete event only, got %+v", events)
+	}
+
+	// New events go to a fresh segment after the restored one
+	s.Append(Event{ID: "ev-3", Timestamp: time.Now().Format(time.RFC3339), Type: EventTraceCreate})
+	if files := segmentFiles(t, dir); len(files) != 2 {
+		t.Errorf("Expected a new segment, got %v", files)
+	}
+	if err := s.Ack("ev-1"); err != nil {
+		t.Fatalf("Ack() failed: %v", err)
+	}
+	if files := segmentFiles(t, dir); len(files) != 1 || !strings.HasSuffix(files[0], "000000000002.ndjson") {
+		t.Errorf("Expected acknowledged segment to be deleted, got %v", files)
+	}
+
+	if _, _, err := OpenSpool(dir, 0, "sideways"); err == nil {
+		t.Error("Expected error for unknown drop policy")
+	}
+}
@@ -0,0 +1,547 @@
+// Package langsmith exports monitor events as LangSmith run trees using the
+// batch ingest endpoint.
+package langsmith
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/user/claude-langfuse-go/internal/langfuse"
+)
+
+// DefaultEndpoint is the LangSmith cloud API.
+const DefaultEndpoint = "https://api.smith.langchain.com"
+
+// DefaultProject is the LangSmith project runs are logged to by default.
+const DefaultProject = "claude-code"
+
+// DefaultMaxQueue is the number of runs kept queued while LangSmith is
+// unreachable; the oldest are dropped beyond it.
+const DefaultMaxQueue = 10000
+
+// nodeTTL is how long the position of a run in its tree is remembered, so
+// that later updates and children can refer to it.
+const nodeTTL = 24 * time.Hour
+
+// namespace seeds the deterministic run IDs derived from Langfuse IDs.
+var namespace = uuid.MustParse("5a1c8e0e-3f4b-4a57-9d1e-6c2b7f0a9e41")
+
+// Run types.
+const (
+	runChain = "chain"
+	runLLM   = "llm"
+	runTool  = "tool"
+)
+
+// Exporter converts ingestion events to runs and posts them to LangSmith.
+type Exporter struct {
+	endpoint   string
+	apiKey     string
+	project    string
+	httpClient *http.Client
+	batchSize  int
+	maxQueue   int
+
+	sendMu sync.Mutex // serialises flushes, which post without mu
+	mu     sync.Mutex
+	queue  []*run
+	queued map[string]*run  // queued runs by run ID
+	nodes  map[string]*node // known runs by run ID
+}
+
+// run is a LangSmith run, sent as a post when new and as a patch when it
+// updates a run that has already been sent.
+type run struct {
+	ID          string                 `json:"id"`
+	TraceID     string                 `json:"trace_id"`
+	DottedOrder string                 `json:"dotted_order"`
+	ParentRunID string                 `json:"parent_run_id,omitempty"`
+	Name        string                 `json:"name,omitempty"`
+	RunType     string                 `json:"run_type,omitempty"`
+	SessionName string                 `json:"session_name,omitempty"`
+	Inputs      map[string]interface{} `json:"inputs,omitempty"`
+	Outputs     map[string]interface{} `json:"outputs,omitempty"`
+	Error       string                 `json:"error,omitempty"`
+	Extra       map[string]interface{} `json:"extra,omitempty"`
+	StartTime   *time.Time             `json:"start_time,omitempty"`
+	EndTime     *time.Time             `json:"end_time,omitempty"`
+
+	patch bool
+}
+
+// node records where a run sits in its tree.
+type node struct {
+	id          string
+	traceID     string
+	dottedOrder string
+	session     string
+	end         time.Time
+	seen        time.Time
+}
+
+// NewExporter creates an exporter for the LangSmith API at endpoint that logs
+// runs to project.
+func NewExporter(endpoint, apiKey, project string, batchSize int) *Exporter {
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	if project == "" {
+		project = DefaultProject
+	}
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	return &Exporter{
+		endpoint:   strings.TrimSuffix(endpoint, "/") + "/runs/batch",
+		apiKey:     apiKey,
+		project:    project,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		batchSize:  batchSize,
+		maxQueue:   DefaultMaxQueue,
+		queued:     make(map[string]*run),
+		nodes:      make(map[string]*node),
+	}
+}
+
+// Export maps an event to a run: traces become chain runs, generations llm
+// runs, and spans tool runs (or chain runs for sub-agents and thinking).
+func (e *Exporter) Export(eventType string, body interface{}) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	switch b := body.(type) {
+	case *langfuse.Trace:
+		var start time.Time
+		if b.Timestamp != nil {
+			start = *b.Timestamp
+		}
+		r, n := e.runLocked(b.ID, "", "", start)
+		setString(&r.Name, b.Name)
+		if r.RunType == "" && !r.patch {
+			r.RunType = runChain
+			r.EndTime = r.StartTime
+			n.end = *r.StartTime
+		}
+		setValue(&r.Inputs, "input", b.Input)
+		setValue(&r.Outputs, "output", b.Output)
+		mergeMetadata(r, b.Metadata)
+		if b.SessionID != "" {
+			n.session = b.SessionID
+			setMetadata(r, "session_id", b.SessionID)
+		}
+		if b.UserID != "" {
+			setMetadata(r, "user_id", b.UserID)
+		}
+	case *langfuse.Generation:
+		r, n := e.runLocked(b.ID, b.TraceID, b.ParentObservationID, b.StartTime)
+		r.RunType = runLLM
+		setString(&r.Name, b.Name)
+		setValue(&r.Inputs, "messages", b.Input)
+		setValue(&r.Outputs, "output", b.Output)
+		mergeMetadata(r, b.Metadata)
+		if b.Model != "" {
+			setMetadata(r, "ls_provider", "anthropic")
+			setMetadata(r, "ls_model_name", b.Model)
+		}
+		if b.UsageDetails != nil {
+			setValue(&r.Outputs, "usage_metadata", usageMetadata(b.UsageDetails))
+		}
+		e.endLocked(r, n, b.EndTime)
+	case *langfuse.Span:
+		var start time.Time
+		if b.StartTime != nil {
+			start = *b.StartTime
+		} else if b.EndTime != nil {
+			start = *b.EndTime
+		}
+		r, n := e.runLocked(b.ID, b.TraceID, b.ParentObservationID, start)
+		setString(&r.Name, b.Name)
+		if r.Name == "" && !r.patch {
+			r.Name = "span"
+		}
+		if b.Name != "" || !r.patch {
+			r.RunType = spanType(r.Name, b.Metadata)
+		}
+		setValue(&r.Inputs, "input", b.Input)
+		setValue(&r.Outputs, "output", b.Output)
+		mergeMetadata(r, b.Metadata)
+		if b.Level == "ERROR" {
+			r.Error = b.StatusMessage
+			if r.Error == "" {
+				r.Error = "error"
+			}
+		}
+		if b.EndTime != nil {
+			e.endLocked(r, n, *b.EndTime)
+		}
+	case *langfuse.EventObservation:
+		r, n := e.runLocked(b.ID, b.TraceID, b.ParentObservationID, b.StartTime)
+		r.RunType = runChain
+		setString(&r.Name, b.Name)
+		setValue(&r.Inputs, "input", b.Input)
+		setValue(&r.Outputs, "output", b.Output)
+		mergeMetadata(r, b.Metadata)
+		if b.Level == "ERROR" {
+			r.Error = b.StatusMessage
+		}
+		e.endLocked(r, n, b.StartTime)
+	default:
+		return fmt.Errorf("unsupported %s body %T", eventType, body)
+	}
+	return nil
+}
+
+// runLocked returns the queued run for an observation (or, with an empty
+// traceKey, for a trace's root run), queueing a post for new runs and a
+// patch for runs that have already been sent.
+func (e *Exporter) runLocked(key, traceKey, parentKey string, start time.Time) (*run, *node) {
+	id := RunID(key)
+	now := time.Now()
+	if n, ok := e.nodes[id]; ok {
+		n.seen = now
+		return e.patchLocked(n), n
+	}
+
+	if start.IsZero() {
+		start = now
+	}
+	n := &node{id: id, traceID: id, dottedOrder: segment(start, id), seen: now}
+	r := &run{ID: id, TraceID: id, SessionName: e.project, StartTime: &start}
+	if traceKey != "" && traceKey != key {
+		parent := e.parentLocked(traceKey, parentKey, start)
+		n.traceID = parent.traceID
+		n.dottedOrder = parent.dottedOrder + "." + n.dottedOrder
+		n.session = parent.session
+		r.TraceID = parent.traceID
+		r.ParentRunID = parent.id
+		if n.session != "" {
+			setMetadata(r, "session_id", n.session)
+		}
+	}
+	r.DottedOrder = n.dottedOrder
+
+	e.nodes[id] = n
+	e.enqueueLocked(r)
+	return r, n
+}
+
+// parentLocked returns the parent of a new run: its parent observation if
+// known, otherwise the root run of its trace. A placeholder root is created
+// for traces that began before the exporter started.
+func (e *Exporter) parentLocked(traceKey, parentKey string, start time.Time) *node {
+	for _, key := range []string{parentKey, traceKey} {
+		if n, ok := e.nodes[RunID(key)]; ok && key != "" {
+			n.seen = time.Now()
+			return n
+		}
+	}
+	r, n := e.runLocked(traceKey, "", "", start)
+	if !r.patch && r.RunType == "" {
+		r.Name = "trace"
+		r.RunType = runChain
+		r.EndTime = r.StartTime
+		n.end = start
+	}
+	return n
+}
+
+// patchLocked returns the queued run for a known node, queueing a patch if
+// it has none.
+func (e *Exporter) patchLocked(n *node) *run {
+	if r, ok := e.queued[n.id]; ok {
+		return r
+	}
+	r := &run{ID: n.id, TraceID: n.traceID, DottedOrder: n.dottedOrder, patch: true}
+	e.enqueueLocked(r)
+	return r
+}
+
+// enqueueLocked adds a run to the next batch.
+func (e *Exporter) enqueueLocked(r *run) {
+	e.queue = append(e.queue, r)
+	e.queued[r.ID] = r
+}
+
+// endLocked sets the end time of a run and extends its root run to cover it.
+func (e *Exporter) endLocked(r *run, n *node, end time.Time) {
+	if end.IsZero() {
+		return
+	}
+	r.EndTime = &end
+	n.end = end
+
+	root, ok := e.nodes[n.traceID]
+	if !ok || root == n || !end.After(root.end) {
+		return
+	}
+	root.end = end
+	rootEnd := end
+	e.patchLocked(root).EndTime = &rootEnd
+}
+
+// Flush sends the queued runs in batches of up to batchSize. Runs stay
+// queued when a request fails temporarily so they are retried, and are
+// dropped when LangSmith rejects their batch. Runs queued during the flush
+// are left for the next one.
+func (e *Exporter) Flush() error {
+	e.sendMu.Lock()
+	defer e.sendMu.Unlock()
+
+	e.mu.Lock()
+	e.pruneLocked()
+	remaining := len(e.queue)
+	e.mu.Unlock()
+
+	for remaining > 0 {
+		e.mu.Lock()
+		batch, body, err := e.takeLocked(remaining)
+		e.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		remaining -= len(batch)
+
+		rejected, err := e.post(body)
+		if err == nil {
+			continue
+		}
+		if rejected {
+			// A rejected batch will never be accepted, so drop it rather
+			// than block every later flush
+			return fmt.Errorf("dropped %d runs: %w", len(batch), err)
+		}
+		e.mu.Lock()
+		e.restoreLocked(batch)
+		err = e.keepLocked(err)
+		e.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Shutdown sends all queued runs.
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	return e.Flush()
+}
+
+// pruneLocked forgets runs that have not been seen for nodeTTL (must be
+// called with lock held).
+func (e *Exporter) pruneLocked() {
+	now := time.Now()
+	for id, n := range e.nodes {
+		if _, queued := e.queued[id]; !queued && now.Sub(n.seen) > nodeTTL {
+			delete(e.nodes, id)
+		}
+	}
+}
+
+// takeLocked removes the next batch of at most limit runs from the queue
+// and encodes it (must be called with lock held). Later updates to the
+// taken runs are queued as new patches.
+func (e *Exporter) takeLocked(limit int) ([]*run, []byte, error) {
+	n := len(e.queue)
+	if n > limit {
+		n = limit
+	}
+	if n > e.batchSize {
+		n = e.batchSize
+	}
+
+	payload := struct {
+		Post  []*run `json:"post"`
+		Patch []*run `json:"patch"`
+	}{Post: []*run{}, Patch: []*run{}}
+	for _, r := range e.queue[:n] {
+		if r.patch {
+			payload.Patch = append(payload.Patch, r)
+		} else {
+			payload.Post = append(payload.Post, r)
+		}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal runs: %w", err)
+	}
+
+	batch := e.queue[:n:n]
+	e.queue = append([]*run(nil), e.queue[n:]...)
+	for _, r := range batch {
+		delete(e.queued, r.ID)
+	}
+	return batch, body, nil
+}
+
+// restoreLocked puts a batch that failed back at the front of the queue,
+// folding in any patches queued for its runs since it was taken (must be
+// called with lock held).
+func (e *Exporter) restoreLocked(batch []*run) {
+	byID := make(map[string]*run, len(batch))
+	for _, r := range batch {
+		byID[r.ID] = r
+	}
+
+	queue := append([]*run(nil), batch...)
+	for _, r := range e.queue {
+		if b, ok := byID[r.ID]; ok {
+			mergeRun(b, r)
+			continue
+		}
+		queue = append(queue, r)
+	}
+
+	e.queue = queue
+	e.queued = make(map[string]*run, len(queue))
+	for _, r := range queue {
+		e.queued[r.ID] = r
+	}
+}
+
+// post sends one batch request. It reports whether LangSmith rejected the
+// batch as a whole.
+func (e *Exporter) post(body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", e.apiKey)
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to send runs: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		respBody, _ := io.ReadAll(resp.Body)
+		err := fmt.Errorf("langsmith API error: status %d, body: %s", resp.StatusCode, string(respBody))
+		return rejectedBatch(resp.StatusCode), err
+	}
+	return false, nil
+}
+
+// keepLocked leaves runs queued after a failed request, dropping the oldest
+// beyond the queue limit, and returns err (must be called with lock held).
+func (e *Exporter) keepLocked(err error) error {
+	over := len(e.queue) - e.maxQueue
+	if over <= 0 {
+		return err
+	}
+	for _, r := range e.queue[:over] {
+		delete(e.queued, r.ID)
+	}
+	e.queue = append([]*run(nil), e.queue[over:]...)
+	return fmt.Errorf("%w (queue full, dropped the %d oldest runs)", err, over)
+}
+
+// rejectedBatch reports whether LangSmith refused a batch as a whole, as
+// opposed to failing temporarily or refusing the API key.
+func rejectedBatch(status int) bool {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return status < 500
+}
+
+// RunID maps a Langfuse trace or observation ID to a LangSmith run ID. The
+// mapping is deterministic so that updates and replays address the same run.
+func RunID(id string) string {
+	return uuid.NewSHA1(namespace, []byte(id)).String()
+}
+
+// segment is one element of a dotted order: the run's start time followed
+// by its ID.
+func segment(start time.Time, id string) string {
+	start = start.UTC()
+	return fmt.Sprintf("%s%06dZ%s", start.Format("20060102T150405"), start.Nanosecond()/1000, id)
+}
+
+// spanType returns the run type of a span: sub-agents and thinking are
+// chains, everything else is a tool call.
+func spanType(name string, metadata map[string]interface{}) string {
+	if sidechain, _ := metadata["isSidechain"].(bool); sidechain || name == "thinking" {
+		return runChain
+	}
+	return runTool
+}
+
+// usageMetadata converts Langfuse usage details to LangSmith token usage.
+func usageMetadata(usage map[string]int) map[string]interface{} {
+	input := usage["input"] + usage["input_cache_read"] + usage["input_cache_creation"]
+	return map[string]interface{}{
+		"input_tokens":  input,
+		"output_tokens": usage["output"],
+		"total_tokens":  input + usage["output"],
+		"input_token_details": map[string]int{
+			"cache_read":     usage["input_cache_read"],
+			"cache_creation": usage["input_cache_creation"],
+		},
+	}
+}
+
+// setValue stores a field value under key, creating the map if needed.
+// Unset values are skipped.
+func setValue(dst *map[string]interface{}, key string, value interface{}) {
+	if value == nil || value == "" {
+		return
+	}
+	if *dst == nil {
+		*dst = make(map[string]interface{})
+	}
+	(*dst)[key] = value
+}
+
+// setMetadata sets a key in the run's extra.metadata.
+func setMetadata(r *run, key string, value interface{}) {
+	if r.Extra == nil {
+		r.Extra = make(map[string]interface{})
+	}
+	metadata, _ := r.Extra["metadata"].(map[string]interface{})
+	if metadata == nil {
+		metadata = make(map[string]interface{})
+		r.Extra["metadata"] = metadata
+	}
+	metadata[key] = value
+}
+
+// mergeMetadata adds Langfuse metadata to the run's extra.metadata.
+func mergeMetadata(r *run, metadata map[string]interface{}) {
+	for key, value := range metadata {
+		setMetadata(r, key, value)
+	}
+}
+
+// mergeRun applies a later patch of the same run to r.
+func mergeRun(r, patch *run) {
+	setString(&r.Name, patch.Name)
+	setString(&r.RunType, patch.RunType)
+	setString(&r.Error, patch.Error)
+	for key, value := range patch.Inputs {
+		setValue(&r.Inputs, key, value)
+	}
+	for key, value := range patch.Outputs {
+		setValue(&r.Outputs, key, value)
+	}
+	metadata, _ := patch.Extra["metadata"].(map[string]interface{})
+	mergeMetadata(r, metadata)
+	if patch.EndTime != nil {
+		r.EndTime = patch.EndTime
+	}
+}
+
+// setString overwrites dst when src is set.
+func setString(dst *string, src string) {
+	if src != "" {
+		*dst = src
+	}
+}
@@ -0,0 +1,285 @@
+package langsmith
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/user/claude-langfuse-go/internal/langfuse"
+)
+
+// batch is a decoded /runs/batch request.
+type batch struct {
+	Post  []run `json:"post"`
+	Patch []run `json:"patch"`
+}
+
+// server is a stand-in LangSmith API that records received batches.
+type server struct {
+	mu      sync.Mutex
+	batches []batch
+}
+
+func newServer(t *testing.T) (*server, *httptest.Server) {
+	t.Helper()
+	s := &server{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/runs/batch" || r.Header.Get("x-api-key") != "key" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		var b batch
+		if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+			t.Errorf("Failed to decode batch: %v", err)
+		}
+		s.mu.Lock()
+		s.batches = append(s.batches, b)
+		s.mu.Unlock()
+	}))
+	t.Cleanup(ts.Close)
+	return s, ts
+}
+
+func TestExporter_RunTree(t *testing.T) {
+	s, ts := newServer(t)
+	e := NewExporter(ts.URL, "key", "team", 0)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	toolStart, toolEnd := start.Add(2*time.Second), start.Add(3*time.Second)
+	events := []struct {
+		eventType string
+		body      interface{}
+	}{
+		{langfuse.EventTraceCreate, &langfuse.Trace{ID: "prompt-1", Name: "claude_code_user", SessionID: "session-1", Input: "Fix it", Timestamp: &start}},
+		{langfuse.EventGenerationCreate, &langfuse.Generation{ID: "gen-1", TraceID: "prompt-1", Name: "claude_response", Model: "claude-sonnet-4",
+			StartTime: start, EndTime: start.Add(time.Second), UsageDetails: map[string]int{"input": 10, "output": 1}}},
+		{langfuse.EventGenerationUpdate, &langfuse.Generation{ID: "gen-1", TraceID: "prompt-1", Name: "claude_response", Model: "claude-sonnet-4",
+			StartTime: start, EndTime: toolStart, Output: "Reading", UsageDetails: map[string]int{"input": 10, "output": 25}}},
+		{langfuse.EventSpanCreate, &langfuse.Span{ID: "toolu_1", TraceID: "prompt-1", ParentObservationID: "gen-1", Name: "Read",
+			Input: map[string]interface{}{"file_path": "/a.go"}, StartTime: &toolStart}},
+	}
+	for _, ev := range events {
+		if err := e.Export(ev.eventType, ev.body); err != nil {
+			t.Fatalf("Export(%s) failed: %v", ev.eventType, err)
+		}
+	}
+	if err := e.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+
+	if len(s.batches) != 1 || len(s.batches[0].Post) != 3 || len(s.batches[0].Patch) != 0 {
+		t.Fatalf("Expected one batch posting 3 runs with updates merged, got %+v", s.batches)
+	}
+	runs := make(map[string]run)
+	for _, r := range s.batches[0].Post {
+		runs[r.RunType] = r
+		if r.TraceID != RunID("prompt-1") || r.SessionName != "team" {
+			t.Errorf("Run %s has trace %s in %s", r.Name, r.TraceID, r.SessionName)
+		}
+		if metadata, _ := r.Extra["metadata"].(map[string]interface{}); metadata["session_id"] != "session-1" {
+			t.Errorf("Run %s is missing the session", r.Name)
+		}
+	}
+
+	root, llm, tool := runs[runChain], runs[runLLM], runs[runTool]
+	if root.ID != RunID("prompt-1") || root.ParentRunID != "" || root.Inputs["input"] != "Fix it" {
+		t.Errorf("Unexpected root run: %+v", root)
+	}
+	if root.DottedOrder != "20240101T000000000000Z"+root.ID {
+		t.Errorf("Unexpected root dotted order %s", root.DottedOrder)
+	}
+
+	if llm.ParentRunID != root.ID || !strings.HasPrefix(llm.DottedOrder, root.DottedOrder+".") {
+		t.Errorf("Expected llm run under the root, got %+v", llm)
+	}
+	usage, _ := llm.Outputs["usage_metadata"].(map[string]interface{})
+	if llm.Outputs["output"] != "Reading" || usage["output_tokens"] != float64(25) || usage["total_tokens"] != float64(35) {
+		t.Errorf("Expected latest output and usage on llm run, got %+v", llm.Outputs)
+	}
+
+	if tool.ParentRunID != llm.ID || !strings.HasPrefix(tool.DottedOrder, llm.DottedOrder+".") {
+		t.Errorf("Expected tool run under the llm run, got %+v", tool)
+	}
+	if !tool.StartTime.Equal(toolStart) {
+		t.Errorf("Expected tool start %v, got %v", toolStart, tool.StartTime)
+	}
+
+	// Updates to sent runs become patches, and extend the root run
+	if err := e.Export(langfuse.EventSpanUpdate, &langfuse.Span{ID: "toolu_1", TraceID: "prompt-1", Output: "no such file",
+		Level: "ERROR", StatusMessage: "no such file", EndTime: &toolEnd}); err != nil {
+		t.Fatalf("Export() failed: %v", err)
+	}
+	if err := e.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() failed: %v", err)
+	}
+
+	if len(s.batches) != 2 || len(s.batches[1].Post) != 0 || len(s.batches[1].Patch) != 2 {
+		t.Fatalf("Expected a batch of 2 patches, got %+v", s.batches)
+	}
+	for _, r := range s.batches[1].Patch {
+		if r.DottedOrder == "" || r.TraceID != root.ID {
+			t.Errorf("Patch is missing its tree position: %+v", r)
+		}
+		if !r.EndTime.Equal(toolEnd) {
+			t.Errorf("Expected patch of %s to end at %v, got %v", r.ID, toolEnd, r.EndTime)
+		}
+		if r.ID == tool.ID && (r.Error != "no such file" || r.Outputs["output"] != "no such file") {
+			t.Errorf("Expected failed tool patch, got %+v", r)
+		}
+	}
+}
+
+func TestExporter_UnknownTrace(t *testing.T) {
+	s, ts := newServer(t)
+	e := NewExporter(ts.URL, "key", "", 0)
+
+	// A tool result for a turn that began before the exporter started
+	end := time.Date(2024, 1, 1, 0, 0, 5, 0, time.UTC)
+	if err := e.Export(langfuse.EventSpanUpdate, &langfuse.Span{ID: "toolu_1", TraceID: "prompt-0", Output: "ok", EndTime: &end}); err != nil {
+		t.Fatalf("Export() failed: %v", err)
+	}
+	if err := e.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+
+	posts := s.batches[0].Post
+	if len(posts) != 2 || posts[0].ID != RunID("prompt-0") || posts[1].ParentRunID != posts[0].ID {
+		t.Fatalf("Expected a placeholder root before the span, got %+v", posts)
+	}
+	if posts[0].SessionName != DefaultProject || posts[1].Name == "" || posts[1].RunType != runTool {
+		t.Errorf("Expected posts to carry required fields, got %+v", posts)
+	}
+}
+
+func TestExporter_FailedBatches(t *testing.T) {
+	var mu sync.Mutex
+	status := http.StatusServiceUnavailable
+	var posted []int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var b batch
+		json.NewDecoder(r.Body).Decode(&b)
+		mu.Lock()
+		defer mu.Unlock()
+		posted = append(posted, len(b.Post))
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	e := NewExporter(ts.URL, "key", "", 100)
+	e.maxQueue = 2
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, id := range []string{"trace-1", "trace-2", "trace-3"} {
+		e.Export(langfuse.EventTraceCreate, &langfuse.Trace{ID: id, Timestamp: &start})
+	}
+
+	// Temporary failures keep the newest runs up to the limit
+	if err := e.Flush(); err == nil || !strings.Contains(err.Error(), "dropped the 1 oldest") {
+		t.Errorf("Expected the oldest run to be dropped from a full queue, got %v", err)
+	}
+	if len(e.queue) != 2 || e.queue[0].ID != RunID("trace-2") || e.queued[RunID("trace-1")] != nil {
+		t.Fatalf("Expected the 2 newest runs to stay queued, got %d", len(e.queue))
+	}
+
+	// A malformed batch is dropped rather than blocking later runs
+	mu.Lock()
+	status = http.StatusUnprocessableEntity
+	mu.Unlock()
+	if err := e.Flush(); err == nil || !strings.Contains(err.Error(), "dropped 2 runs") {
+		t.Errorf("Expected rejected batch to be dropped, got %v", err)
+	}
+	if len(e.queue) != 0 {
+		t.Errorf("Expected empty queue after a rejected batch, got %d runs", len(e.queue))
+	}
+
+	mu.Lock()
+	status = http.StatusOK
+	mu.Unlock()
+	e.Export(langfuse.EventTraceCreate, &langfuse.Trace{ID: "trace-4", Timestamp: &start})
+	if err := e.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	if posted[len(posted)-1] != 1 {
+		t.Errorf("Expected later runs to be sent on their own, got %v", posted)
+	}
+
+	// Rate limits and auth failures are not rejections
+	for _, code := range []int{http.StatusTooManyRequests, http.StatusUnauthorized, http.StatusBadGateway} {
+		if rejectedBatch(code) {
+			t.Errorf("Expected status %d to keep runs queued", code)
+		}
+	}
+}
+
+func TestExporter_ExportDuringFlush(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	var mu sync.Mutex
+	var batches []batch
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var b batch
+		json.NewDecoder(r.Body).Decode(&b)
+		mu.Lock()
+		batches = append(batches, b)
+		first := len(batches) == 1
+		mu.Unlock()
+		if first {
+			started <- struct{}{}
+			<-release
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	// Full batches wait for the flush rather than posting from Export
+	e := NewExporter(ts.URL, "key", "", 1)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	e.Export(langfuse.EventTraceCreate, &langfuse.Trace{ID: "trace-1", Timestamp: &start})
+	e.Export(langfuse.EventTraceCreate, &langfuse.Trace{ID: "trace-2", Timestamp: &start})
+	select {
+	case <-started:
+		t.Fatal("Expected Export() not to post full batches")
+	default:
+	}
+
+	flushed := make(chan error, 1)
+	go func() { flushed <- e.Flush() }()
+	<-started
+
+	done := make(chan error, 1)
+	go func() {
+		done <- e.Export(langfuse.EventTraceCreate, &langfuse.Trace{ID: "trace-1", Output: "done"})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Export() failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected Export() not to wait for LangSmith")
+	}
+	close(release)
+	if err := <-flushed; err == nil {
+		t.Fatal("Expected the failed batch to be reported")
+	}
+
+	// The update made during the failed request is folded into the retry
+	if err := e.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	if len(batches) != 3 || len(batches[1].Post) != 1 || len(batches[1].Patch) != 0 {
+		t.Fatalf("Expected the failed run to be retried on its own, got %+v", batches)
+	}
+	if r := batches[1].Post[0]; r.ID != RunID("trace-1") || r.Outputs["output"] != "done" {
+		t.Errorf("Expected the retried run to carry the later output, got %+v", r)
+	}
+}
+
+func TestRunID(t *testing.T) {
+	if RunID("a") != RunID("a") || RunID("a") == RunID("b") {
+		t.Error("Expected run IDs to be deterministic and distinct")
+	}
+}
@@ -0,0 +1,217 @@
+package monitor
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/user/claude-langfuse-go/internal/langfuse"
+)
+
+// pendingTask is a Task tool call waiting for its sub-agent transcript.
+type pendingTask struct {
+	toolUseID    string
+	traceID      string
+	prompt       string
+	subagentType string
+}
+
+// agentRun is a sub-agent transcript nested under the Task call that
+// spawned it. Sidechain entries are written either inline in the parent
+// conversation or to separate agent-*.jsonl files.
+type agentRun struct {
+	id        string
+	sessionID string
+	traceID   string
+	parentID  string
+	name      string
+	input     string
+	startTime time.Time
+	started   bool
+	orphan    bool // no parent turn is known; traceID is a placeholder
+}
+
+// key identifies the conversation state of the agent's own chain.
+func (a *agentRun) key() string {
+	return a.sessionID + "/" + a.id
+}
+
+// isTaskTool reports whether a tool spawns a sub-agent.
+func isTaskTool(name string) bool {
+	return name == "Task" || name == "Agent"
+}
+
+// registerTask records a Task tool call so a later sidechain can be linked.
+func (m *Monitor) registerTask(sessionID string, block ContentBlock, traceID string) {
+	var input struct {
+		Prompt       string `json:"prompt"`
+		SubagentType string `json:"subagent_type"`
+	}
+	json.Unmarshal(block.Input, &input)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.tasks == nil {
+		m.tasks = make(map[string][]*pendingTask)
+	}
+	m.tasks[sessionID] = append(m.tasks[sessionID], &pendingTask{
+		toolUseID:    block.ID,
+		traceID:      traceID,
+		prompt:       strings.TrimSpace(input.Prompt),
+		subagentType: input.SubagentType,
+	})
+}
+
+// resolveAgent returns the sub-agent run a sidechain entry belongs to. The
+// root entry of a sidechain is matched to a pending Task call by its prompt,
+// falling back to the oldest unmatched call of the session. Runs seen before
+// any turn of their session get a placeholder trace for the session.
+func (m *Monitor) resolveAgent(entry *Entry, sessionID string, timestamp time.Time) *agentRun {
+	prompt := strings.TrimSpace(m.extractText(entry.Message))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conv := m.conversationLocked(sessionID)
+	if conv.agents == nil {
+		conv.agents = make(map[string]*agentRun)
+	}
+
+	agent := conv.agents[entry.ParentUUID]
+	if agent == nil && entry.AgentID != "" {
+		agent = conv.agents["agent:"+entry.AgentID]
+	}
+
+	if agent == nil {
+		agent = &agentRun{
+			id:        "agent-" + entry.UUID,
+			sessionID: sessionID,
+			name:      "agent",
+			input:     prompt,
+			startTime: timestamp,
+		}
+		if entry.AgentID != "" {
+			agent.id = "agent-" + entry.AgentID
+			conv.agents["agent:"+entry.AgentID] = agent
+		}
+
+		if task := m.matchTaskLocked(sessionID, prompt); task != nil {
+			agent.traceID = task.traceID
+			agent.parentID = task.toolUseID
+			if task.subagentType != "" {
+				agent.name = "agent:" + task.subagentType
+			}
+		} else if conv.lastTurn != "" {
+			agent.traceID = conv.lastTurn
+		} else if m.state != nil {
+			agent.traceID = m.state.Turn(sessionID)
+		}
+		if agent.traceID == "" {
+			agent.traceID = orphanTraceID(sessionID)
+			agent.orphan = true
+		}
+	}
+
+	if entry.UUID != "" {
+		conv.agents[entry.UUID] = agent
+	}
+	if entry.Type == "user" {
+		m.conversationLocked(agent.key()).lastInput = timestamp
+	}
+
+	return agent
+}
+
+// orphanTraceID returns the placeholder trace of a session's sub-agent runs
+// whose parent turn is unknown.
+func orphanTraceID(sessionID string) string {
+	return "agents-" + sessionID
+}
+
+// matchTaskLocked removes and returns the pending Task call for a sub-agent
+// prompt (m.mu must be held).
+func (m *Monitor) matchTaskLocked(sessionID, prompt string) *pendingTask {
+	tasks := m.tasks[sessionID]
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	idx := 0
+	for i, task := range tasks {
+		if task.prompt != "" && task.prompt == prompt {
+			idx = i
+			break
+		}
+	}
+
+	task := tasks[idx]
+	m.tasks[sessionID] = append(tasks[:idx:idx], tasks[idx+1:]...)
+	return task
+}
+
+// startAgentSpan opens the span that nests a sub-agent's observations.
+func (m *Monitor) startAgentSpan(agent *agentRun, projectPath, agentID string) {
+	m.mu.Lock()
+	if agent.started {
+		m.mu.Unlock()
+		return
+	}
+	agent.started = true
+	createTrace := agent.orphan && !m.processedMessages[agent.traceID]
+	if createTrace {
+		m.processedMessages[agent.traceID] = true
+	}
+	m.mu.Unlock()
+
+	if createTrace {
+		trace := &langfuse.Trace{
+			ID:        agent.traceID,
+			Name:      "agents",
+			SessionID: agent.sessionID,
+			UserID:    m.config.UserID,
+			Metadata: map[string]interface{}{
+				"isSidechain": true,
+				"source":      m.config.Source,
+			},
+			Timestamp: &agent.startTime,
+		}
+		if err := m.createTrace(projectPath, trace); err != nil {
+			color.Red("Error creating agent trace: %v", err)
+		}
+	}
+
+	span := &langfuse.Span{
+		ID:                  agent.id,
+		TraceID:             agent.traceID,
+		ParentObservationID: agent.parentID,
+		Name:                agent.name,
+		Metadata: map[string]interface{}{
+			"isSidechain": true,
+			"source":      m.config.Source,
+		},
+		Input:     agent.input,
+		StartTime: &agent.startTime,
+	}
+	if agentID != "" {
+		span.Metadata["agentId"] = agentID
+	}
+
+	if err := m.createSpan(projectPath, span); err != nil {
+		color.Red("Error creating agent span: %v", err)
+	}
+}
+
+// updateAgentOutput extends the agent span to its latest answer.
+func (m *Monitor) updateAgentOutput(agent *agentRun, projectPath, output string, timestamp time.Time) error {
+	span := &langfuse.Span{
+		ID:      agent.id,
+		TraceID: agent.traceID,
+		EndTime: &timestamp,
+	}
+	if output != "" {
+		span.Output = output
+	}
+	return m.updateSpan(projectPath, span)
+}
@@ -0,0 +1,97 @@
+package monitor
+
+import (
+	"encoding/json"
+
+	"github.com/user/claude-langfuse-go/internal/config"
+)
+
+// chatMessage is a message in Anthropic/OpenAI chat format, which the
+// Langfuse chat view renders with roles and tool calls.
+type chatMessage struct {
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"`
+}
+
+// chatFormat reports whether generations carry structured chat content.
+func (m *Monitor) chatFormat() bool {
+	return m.config != nil && m.config.ContentFormat == config.ContentFormatChat
+}
+
+// recordHistory appends a user entry to the chat history of its turn,
+// preceded by the assistant responses it answers. Prompts reset the history.
+func (m *Monitor) recordHistory(entry *Entry, sessionID string, isPrompt bool) {
+	if !m.chatFormat() || entry.Type != "user" {
+		return
+	}
+
+	msg := chatMessage{Role: "user", Content: m.chatContent(entry.Message)}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conv := m.conversationLocked(sessionID)
+	if isPrompt {
+		conv.history = []chatMessage{msg}
+		conv.responses = nil
+		return
+	}
+
+	for _, p := range conv.responses {
+		conv.history = append(conv.history, chatMessage{Role: "assistant", Content: chatBlocks(p.blocks, m.captureThinking())})
+	}
+	conv.responses = nil
+	conv.history = append(conv.history, msg)
+}
+
+// chatContent converts the message field into chat message content.
+func (m *Monitor) chatContent(rawMessage json.RawMessage) interface{} {
+	var strMessage string
+	if err := json.Unmarshal(rawMessage, &strMessage); err == nil {
+		return strMessage
+	}
+
+	var msgContent MessageContent
+	if err := json.Unmarshal(rawMessage, &msgContent); err != nil {
+		return ""
+	}
+	if len(msgContent.Content) == 0 {
+		return msgContent.Text
+	}
+	return chatBlocks(msgContent.Content, m.captureThinking())
+}
+
+// chatBlocks converts content blocks into Anthropic-style block objects,
+// dropping fields that do not apply to each block type.
+func chatBlocks(blocks []ContentBlock, includeThinking bool) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(blocks))
+	for _, block := range blocks {
+		switch block.Type {
+		case "thinking":
+			if includeThinking {
+				result = append(result, map[string]interface{}{"type": block.Type, "thinking": block.Thinking})
+			}
+		case "redacted_thinking":
+			if includeThinking {
+				result = append(result, map[string]interface{}{"type": block.Type})
+			}
+		case "tool_use":
+			b := map[string]interface{}{"type": block.Type, "id": block.ID, "name": block.Name}
+			if len(block.Input) > 0 {
+				b["input"] = block.Input
+			}
+			result = append(result, b)
+		case "tool_result":
+			b := map[string]interface{}{"type": block.Type, "tool_use_id": block.ToolUseID, "content": toolOutput(block)}
+			if block.IsError {
+				b["is_error"] = true
+			}
+			result = append(result, b)
+		default:
+			if block.Text != "" {
+				result = append(result, map[string]interface{}{"type": block.Type, "text": block.Text})
+			}
+		}
+	}
+	return result
+}
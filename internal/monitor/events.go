@@ -0,0 +1,173 @@
+package monitor
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/user/claude-langfuse-go/internal/langfuse"
+)
+
+// isEventEntry reports whether an entry is sent as a Langfuse event rather
+// than as part of the conversation: summaries, system entries (hook output,
+// API errors, compaction boundaries) and compaction summaries.
+func isEventEntry(entry *Entry) bool {
+	return entry.Type == "summary" || entry.Type == "system" || entry.IsCompactSummary
+}
+
+// processEvent sends a summary, system or compaction entry as an event on
+// the trace of the turn it occurs in.
+func (m *Monitor) processEvent(entry *Entry, sessionID, traceID, projectPath, conversationID string, timestamp time.Time) {
+	var key, name string
+	var input, output interface{}
+	switch {
+	case entry.Type == "summary":
+		if entry.Summary == "" {
+			return
+		}
+		key = "summary-" + entry.LeafUUID
+		name = "summary"
+		output = entry.Summary
+		traceID = m.recordSummary(entry, sessionID)
+	case entry.Type == "system":
+		key = entry.UUID
+		name = entry.Subtype
+		if name == "" {
+			name = "system"
+		}
+		input = entry.Content
+	default:
+		key = entry.UUID
+		name = "compact_summary"
+		input = m.extractText(entry.Message)
+	}
+	if key == "" {
+		return
+	}
+
+	// Check deduplication, including events sent by previous runs
+	m.mu.Lock()
+	if m.processedMessages[key] || (m.state != nil && m.state.IsSent(key)) {
+		m.mu.Unlock()
+		return
+	}
+	m.processedMessages[key] = true
+	m.mu.Unlock()
+
+	// Print activity (unless quiet mode)
+	if !m.options.Quiet {
+		preview, _ := input.(string)
+		if preview == "" {
+			preview, _ = output.(string)
+		}
+		preview = strings.ReplaceAll(truncate(preview, 60), "\n", " ")
+
+		gray := color.New(color.FgHiBlack)
+		gray.Printf("[%s] [%s] %s...\n", name, filepath.Base(projectPath), preview)
+	}
+
+	if m.options.DryRun || m.client == nil {
+		return
+	}
+
+	metadata := map[string]interface{}{
+		"project":        projectPath,
+		"conversationId": conversationID,
+		"messageType":    entry.Type,
+		"source":         m.config.Source,
+	}
+	if entry.Subtype != "" {
+		metadata["subtype"] = entry.Subtype
+	}
+	if entry.LeafUUID != "" {
+		metadata["leafUuid"] = entry.LeafUUID
+	}
+	if len(entry.CompactMetadata) > 0 {
+		metadata["compactMetadata"] = entry.CompactMetadata
+	}
+
+	// Entries outside any turn get a trace of their own
+	if traceID == "" {
+		traceID = key
+		trace := &langfuse.Trace{
+			ID:        traceID,
+			Name:      entry.Type,
+			SessionID: sessionID,
+			UserID:    m.config.UserID,
+			Metadata:  metadata,
+			Timestamp: &timestamp,
+		}
+		if err := m.createTrace(projectPath, trace); err != nil {
+			color.Red("Error creating trace: %v", err)
+		}
+	}
+
+	if entry.Type == "summary" {
+		// Name the summarized trace after the conversation summary
+		if err := m.createTrace(projectPath, &langfuse.Trace{ID: traceID, Name: entry.Summary}); err != nil {
+			color.Red("Error updating trace: %v", err)
+		}
+	}
+
+	ev := &langfuse.EventObservation{
+		ID:                  key,
+		TraceID:             traceID,
+		ParentObservationID: entry.ToolUseID,
+		Name:                name,
+		Metadata:            metadata,
+		Input:               input,
+		Output:              output,
+		Level:               eventLevel(entry.Level),
+		StartTime:           timestamp,
+	}
+	if ev.Level == "ERROR" || ev.Level == "WARNING" {
+		ev.StatusMessage = truncate(entry.Content, 500)
+	}
+
+	if err := m.createEvent(projectPath, ev); err != nil {
+		color.Red("Error creating event: %v", err)
+	}
+	m.markSent(key)
+}
+
+// recordSummary stores the summary on the conversation and returns the trace
+// of the turn it summarizes, which may live in another conversation file.
+func (m *Monitor) recordSummary(entry *Entry, sessionID string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conv := m.conversationLocked(sessionID)
+	conv.summary = entry.Summary
+
+	if traceID, ok := conv.turnOf[entry.LeafUUID]; ok {
+		return traceID
+	}
+	for _, other := range m.conversations {
+		if traceID, ok := other.turnOf[entry.LeafUUID]; ok {
+			return traceID
+		}
+	}
+	return conv.lastTurn
+}
+
+// conversationSummary returns the latest summary seen for a session.
+func (m *Monitor) conversationSummary(sessionID string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.conversationLocked(sessionID).summary
+}
+
+// eventLevel maps a Claude Code entry level to a Langfuse observation level.
+func eventLevel(level string) string {
+	switch strings.ToLower(level) {
+	case "error":
+		return "ERROR"
+	case "warning", "warn":
+		return "WARNING"
+	case "debug":
+		return "DEBUG"
+	default:
+		return "DEFAULT"
+	}
+}
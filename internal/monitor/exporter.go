@@ -0,0 +1,329 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fatih/color"
+	"github.com/user/claude-langfuse-go/internal/archive"
+	"github.com/user/claude-langfuse-go/internal/config"
+	"github.com/user/claude-langfuse-go/internal/langfuse"
+	"github.com/user/claude-langfuse-go/internal/langsmith"
+	"github.com/user/claude-langfuse-go/internal/otlp"
+)
+
+// Exporter receives ingestion events. *langfuse.Client is the primary
+// implementation; sinks add further outputs alongside it.
+type Exporter interface {
+	// Export queues an event; body is a *langfuse.Trace, *langfuse.Generation,
+	// *langfuse.Span or *langfuse.EventObservation depending on eventType.
+	Export(eventType string, body interface{}) error
+	// Flush sends all queued events.
+	Flush() error
+	// Shutdown flushes remaining events and releases resources, giving up
+	// when ctx expires.
+	Shutdown(ctx context.Context) error
+}
+
+// output is flushed and shut down along with the monitor.
+type output interface {
+	Flush() error
+	Shutdown(ctx context.Context) error
+}
+
+// sink is an additional output that receives every event its filter
+// accepts. Archives instead record the events each destination delivered.
+type sink struct {
+	name     string
+	exporter Exporter
+	archive  *archive.Writer
+	optional bool
+	filter   config.Sink
+}
+
+// output returns the archive or exporter of the sink.
+func (s *sink) output() output {
+	if s.archive != nil {
+		return s.archive
+	}
+	return s.exporter
+}
+
+// newSinks creates the enabled sinks configured in cfg.
+func newSinks(cfg *config.Config) ([]*sink, error) {
+	var sinks []*sink
+	for _, sc := range cfg.Sinks {
+		if sc.Disabled {
+			continue
+		}
+
+		name := sc.Name
+		if name == "" {
+			name = sc.Type
+		}
+
+		var exporter Exporter
+		var writer *archive.Writer
+		switch sc.Type {
+		case config.SinkLangfuse:
+			dest := config.Destination{
+				Name:          name,
+				Host:          sc.Host,
+				PublicKey:     sc.PublicKey,
+				SecretKey:     sc.SecretKey,
+				BatchSize:     sc.BatchSize,
+				MaxBatchBytes: sc.MaxBatchBytes,
+			}
+			client, err := newLangfuseClient(cfg, dest, "sink-"+name)
+			if err != nil {
+				return nil, err
+			}
+			exporter = client
+		case config.SinkNDJSON:
+			if len(sc.Projects) > 0 {
+				return nil, fmt.Errorf("sink %q archives whole destinations and cannot filter by project", name)
+			}
+			dir := archive.DefaultDir()
+			if sc.Dir != "" {
+				dir = config.ExpandHome(sc.Dir)
+			}
+			writer = archive.NewWriter(dir, sc.BatchSize, sc.MaxFileBytes)
+		case config.SinkOTLP:
+			if sc.Endpoint == "" {
+				return nil, fmt.Errorf("sink %q has no endpoint", name)
+			}
+			exporter = otlp.NewExporter(sc.Endpoint, sc.Headers, sc.ServiceName, sc.BatchSize)
+		case config.SinkLangSmith:
+			apiKey := sc.APIKey
+			if apiKey == "" {
+				apiKey = os.Getenv("LANGSMITH_API_KEY")
+			}
+			if apiKey == "" {
+				return nil, fmt.Errorf("sink %q has no API key", name)
+			}
+			exporter = langsmith.NewExporter(sc.Endpoint, apiKey, sc.Project, sc.BatchSize)
+		default:
+			return nil, fmt.Errorf("sink %q has unknown type %q", name, sc.Type)
+		}
+
+		sinks = append(sinks, &sink{name: name, exporter: exporter, archive: writer, optional: sc.Optional, filter: sc})
+	}
+	return sinks, nil
+}
+
+// attachArchives has every destination record the events Langfuse accepted
+// to the archive sinks that accept the destination. Archive errors are
+// logged, as they happen while the client is sending.
+func (m *Monitor) attachArchives() {
+	clients := map[string]Exporter{config.DefaultDestination: m.client}
+	for name, client := range m.clients {
+		clients[name] = client
+	}
+
+	for name, e := range clients {
+		client, ok := e.(*langfuse.Client)
+		if !ok {
+			continue
+		}
+		var archives []*sink
+		for _, s := range m.sinks {
+			if s.archive != nil && s.filter.Accepts("", name) {
+				archives = append(archives, s)
+			}
+		}
+		if len(archives) == 0 {
+			continue
+		}
+
+		name := name
+		client.SetDeliveryHandler(func(line []byte) {
+			for _, s := range archives {
+				if err := s.archive.Append(name, line); err != nil {
+					color.Red("Error archiving to sink %s: %v", s.name, err)
+				}
+			}
+		})
+	}
+}
+
+// newLangfuseClient creates a Langfuse client for dest that logs flushes it
+// gives up on and events that Langfuse rejects. Batch limits not set on dest
+// fall back to the top-level ones. Unless disabled, its queue is spooled to
+// spoolName under the spool directory.
+func newLangfuseClient(cfg *config.Config, dest config.Destination, spoolName string) (*langfuse.Client, error) {
+	name := dest.Name
+	client := langfuse.NewClient(dest.Host, dest.PublicKey, dest.SecretKey)
+	if dest.BatchSize == 0 {
+		dest.BatchSize = cfg.BatchSize
+	}
+	if dest.MaxBatchBytes == 0 {
+		dest.MaxBatchBytes = cfg.MaxBatchBytes
+	}
+	client.SetBatchSize(dest.BatchSize)
+	client.SetMaxBatchBytes(dest.MaxBatchBytes)
+	client.SetFlushInterval(cfg.FlushInterval())
+	client.SetQueueSize(cfg.QueueSize)
+	if err := client.SetBackpressure(cfg.Backpressure); err != nil {
+		return nil, err
+	}
+	client.SetFailureHandler(func(err error, events int) {
+		color.Red("Error sending %d events to %s: %v", events, name, err)
+	})
+	client.SetRejectionHandler(func(r langfuse.Rejection) {
+		color.Red("Error: %s rejected %s %s (event %s): status %d: %s", name, r.EventType, r.BodyID, r.EventID, r.Status, r.Message)
+	})
+
+	if !cfg.DisableSpool {
+		dir := filepath.Join(cfg.SpoolPath(), spoolName)
+		restored, err := client.OpenSpool(dir, cfg.SpoolMaxBytes, cfg.SpoolDropPolicy)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open spool for %s: %w", name, err)
+		}
+		if restored > 0 {
+			color.Yellow("Restored %d unsent events for %s", restored, name)
+		}
+	}
+	return client, nil
+}
+
+// DeliveryStats returns the delivery counters of every Langfuse destination
+// and sink, by name.
+func (m *Monitor) DeliveryStats() map[string]langfuse.DeliveryStats {
+	stats := make(map[string]langfuse.DeliveryStats)
+	add := func(name string, e Exporter) {
+		if client, ok := e.(*langfuse.Client); ok {
+			stats[name] = client.Stats()
+		}
+	}
+
+	add(config.DefaultDestination, m.client)
+	for name, client := range m.clients {
+		add(name, client)
+	}
+	for _, s := range m.sinks {
+		add(s.name, s.exporter)
+	}
+	return stats
+}
+
+// export sends an event to the destination of its project and to every
+// exporting sink that accepts the project and destination. Failures of
+// optional sinks are logged rather than returned.
+func (m *Monitor) export(projectPath, eventType string, body interface{}) error {
+	client, firstErr := m.clientFor(projectPath)
+	if client != nil {
+		firstErr = client.Export(eventType, body)
+	}
+
+	dest := m.destinationFor(projectPath)
+	for _, s := range m.sinks {
+		if s.exporter == nil || !s.filter.Accepts(projectPath, dest) {
+			continue
+		}
+		if err := s.exporter.Export(eventType, body); err != nil {
+			if s.optional {
+				color.Red("Error exporting to sink %s: %v", s.name, err)
+			} else if firstErr == nil {
+				firstErr = fmt.Errorf("sink %s: %w", s.name, err)
+			}
+		}
+	}
+	return firstErr
+}
+
+// forEachOutput applies fn (Flush or Shutdown) to every destination and
+// sink, returning the first error from an output that is not optional.
+func (m *Monitor) forEachOutput(fn func(output) error) error {
+	var firstErr error
+	for _, client := range m.allClients() {
+		if err := fn(client); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if err := m.forEachSink(fn); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+// forEachSink applies fn to every sink, logging failures of optional sinks
+// and returning the first error from the others.
+func (m *Monitor) forEachSink(fn func(output) error) error {
+	var firstErr error
+	for _, s := range m.sinks {
+		if err := fn(s.output()); err != nil {
+			if s.optional {
+				color.Red("Error flushing sink %s: %v", s.name, err)
+			} else if firstErr == nil {
+				firstErr = fmt.Errorf("sink %s: %w", s.name, err)
+			}
+		}
+	}
+	return firstErr
+}
+
+// flushSinks flushes the sinks that do not send on their own interval, as
+// Langfuse clients do.
+func (m *Monitor) flushSinks() error {
+	return m.forEachSink(func(o output) error {
+		if _, ok := o.(*langfuse.Client); ok {
+			return nil
+		}
+		return o.Flush()
+	})
+}
+
+// delivered reports whether every Langfuse destination, and every Langfuse
+// sink that is not optional, has sent or spooled the events it was given.
+func (m *Monitor) delivered() bool {
+	clients := m.allClients()
+	for _, s := range m.sinks {
+		if !s.optional && s.exporter != nil {
+			clients = append(clients, s.exporter)
+		}
+	}
+	for _, e := range clients {
+		if client, ok := e.(*langfuse.Client); ok && !client.Delivered() {
+			return false
+		}
+	}
+	return true
+}
+
+// createTrace redacts a trace and exports it.
+func (m *Monitor) createTrace(projectPath string, trace *langfuse.Trace) error {
+	m.redactor.redactFields(&trace.Input, &trace.Output, &trace.Metadata, &trace.Name)
+	return m.export(projectPath, langfuse.EventTraceCreate, trace)
+}
+
+// createGeneration redacts a new generation and exports it.
+func (m *Monitor) createGeneration(projectPath string, gen *langfuse.Generation) error {
+	m.redactor.redactFields(&gen.Input, &gen.Output, &gen.Metadata, &gen.Name)
+	return m.export(projectPath, langfuse.EventGenerationCreate, gen)
+}
+
+// updateGeneration redacts a generation update and exports it.
+func (m *Monitor) updateGeneration(projectPath string, gen *langfuse.Generation) error {
+	m.redactor.redactFields(&gen.Input, &gen.Output, &gen.Metadata, &gen.Name)
+	return m.export(projectPath, langfuse.EventGenerationUpdate, gen)
+}
+
+// createSpan redacts a new span and exports it.
+func (m *Monitor) createSpan(projectPath string, span *langfuse.Span) error {
+	m.redactor.redactFields(&span.Input, &span.Output, &span.Metadata, &span.Name, &span.StatusMessage)
+	return m.export(projectPath, langfuse.EventSpanCreate, span)
+}
+
+// updateSpan redacts a span update and exports it.
+func (m *Monitor) updateSpan(projectPath string, span *langfuse.Span) error {
+	m.redactor.redactFields(&span.Input, &span.Output, &span.Metadata, &span.Name, &span.StatusMessage)
+	return m.export(projectPath, langfuse.EventSpanUpdate, span)
+}
+
+// createEvent redacts an event observation and exports it.
+func (m *Monitor) createEvent(projectPath string, ev *langfuse.EventObservation) error {
+	m.redactor.redactFields(&ev.Input, &ev.Output, &ev.Metadata, &ev.Name, &ev.StatusMessage)
+	return m.export(projectPath, langfuse.EventEventCreate, ev)
+}
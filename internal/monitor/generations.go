@@ -0,0 +1,116 @@
+package monitor
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/user/claude-langfuse-go/internal/langfuse"
+)
+
+// pendingGeneration accumulates the streamed chunks of one API response.
+type pendingGeneration struct {
+	gen    *langfuse.Generation
+	blocks []ContentBlock
+	chunks int
+}
+
+// mergeGeneration folds an assistant entry into the generation for its
+// requestId and returns a snapshot to send. The generation starts at the
+// preceding prompt or tool result and ends at the latest chunk. isNew is
+// true for the first chunk of a response. parentID nests the generation
+// under another observation, such as a sub-agent span.
+func (m *Monitor) mergeGeneration(entry *Entry, sessionID, traceID, parentID, projectPath, conversationID string, timestamp time.Time) (gen *langfuse.Generation, isNew bool) {
+	blocks := m.extractBlocks(entry.Message)
+	if len(blocks) == 0 {
+		if text := m.extractContent(entry.Message); text != "" {
+			blocks = []ContentBlock{{Type: "text", Text: text}}
+		}
+	}
+	model := m.extractModel(entry.Message)
+	usage := m.extractUsage(entry.Message)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conv := m.conversationLocked(sessionID)
+	p := conv.generations[entry.RequestID]
+	if p == nil || entry.RequestID == "" {
+		startTime := timestamp
+		if !conv.lastInput.IsZero() && !conv.lastInput.After(timestamp) {
+			startTime = conv.lastInput
+		}
+
+		p = &pendingGeneration{
+			gen: &langfuse.Generation{
+				ID:                  entry.UUID,
+				TraceID:             traceID,
+				ParentObservationID: parentID,
+				Name:                m.config.AssistantTraceName,
+				Model:               m.config.Model,
+				Metadata: map[string]interface{}{
+					"project":        projectPath,
+					"conversationId": conversationID,
+					"requestId":      entry.RequestID,
+					"messageType":    entry.Type,
+					"source":         m.config.Source,
+				},
+				StartTime: startTime,
+			},
+		}
+		isNew = true
+
+		if m.chatFormat() {
+			p.gen.Input = append([]chatMessage(nil), conv.history...)
+			conv.responses = append(conv.responses, p)
+		}
+
+		if entry.RequestID != "" {
+			if conv.generations == nil {
+				conv.generations = make(map[string]*pendingGeneration)
+			}
+			conv.generations[entry.RequestID] = p
+		}
+	}
+
+	p.blocks = append(p.blocks, blocks...)
+	p.chunks++
+
+	p.gen.EndTime = timestamp
+	if m.chatFormat() {
+		p.gen.Output = chatMessage{Role: "assistant", Content: chatBlocks(p.blocks, m.captureThinking())}
+	} else {
+		p.gen.Output = formatBlocks(p.blocks)
+	}
+	p.gen.Metadata["chunks"] = p.chunks
+	// Extract model from message if available, fallback to config
+	if model != "" {
+		p.gen.Model = model
+	}
+	if usage != nil {
+		p.gen.UsageDetails = usage.Details()
+		if usage.ServiceTier != "" {
+			p.gen.Metadata["serviceTier"] = usage.ServiceTier
+		}
+	}
+
+	// Queued events keep a pointer to the body, so hand out a copy
+	snapshot := *p.gen
+	snapshot.Metadata = make(map[string]interface{}, len(p.gen.Metadata))
+	for k, v := range p.gen.Metadata {
+		snapshot.Metadata[k] = v
+	}
+
+	return &snapshot, isNew
+}
+
+// generationText returns the text answer accumulated so far for a response.
+func (m *Monitor) generationText(sessionID, requestID string, rawMessage json.RawMessage) string {
+	if requestID != "" {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if p := m.conversationLocked(sessionID).generations[requestID]; p != nil {
+			return blocksText(p.blocks)
+		}
+	}
+	return m.extractText(rawMessage)
+}
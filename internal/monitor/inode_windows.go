@@ -0,0 +1,10 @@
+//go:build windows
+
+package monitor
+
+import "os"
+
+// fileInode is not supported on Windows; replacement is detected by size only.
+func fileInode(info os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,166 @@
+package monitor
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/user/claude-langfuse-go/internal/langfuse"
+)
+
+// Media uploads run in the background so that slow uploads do not hold up
+// file processing. Messages beyond the queue keep placeholders instead.
+const (
+	mediaWorkers   = 2                // uploads running at once
+	mediaQueueSize = 32               // messages waiting or uploading
+	mediaTimeout   = 30 * time.Second // limit for one image or document
+)
+
+// MediaSource is the source of an image or document content block.
+type MediaSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+// mediaUploader is implemented by exporters that can store binary content.
+type mediaUploader interface {
+	UploadMedia(ctx context.Context, traceID, field, contentType string, data []byte) (string, error)
+}
+
+// mediaQueue bounds the number of messages whose media is being uploaded
+// and of uploads running at once.
+type mediaQueue struct {
+	pending chan struct{}
+	active  chan struct{}
+	wg      sync.WaitGroup
+}
+
+// isMedia reports whether a block carries inline binary content.
+func isMedia(block ContentBlock) bool {
+	return (block.Type == "image" || block.Type == "document") &&
+		block.Source != nil && block.Source.Type == "base64"
+}
+
+// mediaBlocks returns the images and documents of a message.
+func mediaBlocks(blocks []ContentBlock) []ContentBlock {
+	var media []ContentBlock
+	for _, block := range blocks {
+		if isMedia(block) {
+			media = append(media, block)
+		}
+	}
+	return media
+}
+
+// mediaUploads returns the monitor's upload queue, creating it on first use.
+func (m *Monitor) mediaUploads() *mediaQueue {
+	m.mediaOnce.Do(func() {
+		m.media = &mediaQueue{
+			pending: make(chan struct{}, mediaQueueSize),
+			active:  make(chan struct{}, mediaWorkers),
+		}
+	})
+	return m.media
+}
+
+// attachMedia uploads the media of a prompt in the background and then
+// updates the trace input to text followed by the reference tokens (or
+// placeholders for skipped content). When the queue is full the input gets
+// placeholders right away.
+func (m *Monitor) attachMedia(projectPath string, media []ContentBlock, traceID, text string) {
+	q := m.mediaUploads()
+	select {
+	case q.pending <- struct{}{}:
+	default:
+		var refs []string
+		for _, block := range media {
+			refs = append(refs, fmt.Sprintf("[%s omitted: upload queue full]", block.Source.MediaType))
+		}
+		m.setMediaInput(projectPath, traceID, text, refs)
+		return
+	}
+
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		defer func() { <-q.pending }()
+		q.active <- struct{}{}
+		refs := m.uploadMedia(projectPath, media, traceID, "input")
+		<-q.active
+		m.setMediaInput(projectPath, traceID, text, refs)
+	}()
+}
+
+// setMediaInput sends a trace update setting its input to text and refs.
+func (m *Monitor) setMediaInput(projectPath, traceID, text string, refs []string) {
+	if text != "" {
+		refs = append([]string{text}, refs...)
+	}
+	trace := &langfuse.Trace{ID: traceID, Input: strings.Join(refs, "\n\n")}
+	if err := m.createTrace(projectPath, trace); err != nil {
+		color.Red("Error updating trace media: %v", err)
+	}
+}
+
+// waitMedia waits for queued media uploads to finish, giving up when ctx
+// expires.
+func (m *Monitor) waitMedia(ctx context.Context) error {
+	q := m.mediaUploads()
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("media uploads still pending at shutdown: %w", ctx.Err())
+	}
+}
+
+// uploadMedia uploads images and documents as Langfuse media attached to
+// the trace field, and returns the reference tokens (or placeholders for
+// skipped content) to include in that field.
+func (m *Monitor) uploadMedia(projectPath string, media []ContentBlock, traceID, field string) []string {
+	var refs []string
+	for _, block := range media {
+		contentType := block.Source.MediaType
+		if m.config.SkipMedia {
+			refs = append(refs, fmt.Sprintf("[%s omitted]", contentType))
+			continue
+		}
+
+		data, err := base64.StdEncoding.DecodeString(block.Source.Data)
+		if err != nil {
+			refs = append(refs, fmt.Sprintf("[%s omitted: invalid data]", contentType))
+			continue
+		}
+		if m.config.MaxMediaBytes > 0 && len(data) > m.config.MaxMediaBytes {
+			refs = append(refs, fmt.Sprintf("[%s omitted: %d bytes exceeds limit]", contentType, len(data)))
+			continue
+		}
+
+		client, _ := m.clientFor(projectPath)
+		uploader, ok := client.(mediaUploader)
+		if !ok {
+			refs = append(refs, fmt.Sprintf("[%s omitted]", contentType))
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), mediaTimeout)
+		mediaID, err := uploader.UploadMedia(ctx, traceID, field, contentType, data)
+		cancel()
+		if err != nil {
+			color.Red("Error uploading media: %v", err)
+			refs = append(refs, fmt.Sprintf("[%s upload failed]", contentType))
+			continue
+		}
+		refs = append(refs, langfuse.MediaReference(contentType, mediaID))
+	}
+	return refs
+}
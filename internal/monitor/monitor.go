@@ -2,14 +2,15 @@
 package monitor
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -17,6 +18,7 @@ import (
 	"github.com/fatih/color"
 	"github.com/user/claude-langfuse-go/internal/config"
 	"github.com/user/claude-langfuse-go/internal/langfuse"
+	"github.com/user/claude-langfuse-go/internal/state"
 )
 
 // Options configures the monitor behavior.
@@ -29,13 +31,34 @@ type Options struct {
 
 // Monitor watches Claude Code conversation files and creates Langfuse traces.
 type Monitor struct {
-	options Options
-	config  *config.Config
-	client  *langfuse.Client
+	options  Options
+	config   *config.Config
+	client   Exporter            // default destination
+	clients  map[string]Exporter // routed destinations by name
+	sinks    []*sink             // additional outputs
+	state    *state.Store
+	redactor *redactor
+
+	// Periodic flush started by Start
+	stopFlush chan struct{}
+	flushDone chan struct{}
+
+	// Background media uploads
+	mediaOnce sync.Once
+	media     *mediaQueue
 
 	mu                   sync.Mutex
 	processedMessages    map[string]bool
 	conversationSessions map[string]string
+	cursors              map[string]*fileCursor
+	pendingTools         map[string]*pendingTool
+	conversations        map[string]*conversation
+	tasks                map[string][]*pendingTask
+	projects             map[string]string // conversation file -> resolved project
+	cwdScans             map[string]int64  // conversation file -> offset scanned for a cwd
+	gitRoots             map[string]string // cwd -> git root
+	optOuts              map[string]optOut // project -> opt-out check
+	projectRoutes        map[string]string // project -> destination
 	messageCount         struct {
 		user      int
 		assistant int
@@ -44,14 +67,28 @@ type Monitor struct {
 
 // Entry represents a JSONL conversation entry.
 type Entry struct {
-	Type       string          `json:"type"`
-	UUID       string          `json:"uuid"`
-	ParentUUID string          `json:"parentUuid"`
-	Timestamp  string          `json:"timestamp"`
-	Message    json.RawMessage `json:"message"`
-	GitBranch  string          `json:"gitBranch"`
-	Cwd        string          `json:"cwd"`
-	RequestID  string          `json:"requestId"`
+	Type        string          `json:"type"`
+	UUID        string          `json:"uuid"`
+	ParentUUID  string          `json:"parentUuid"`
+	Timestamp   string          `json:"timestamp"`
+	Message     json.RawMessage `json:"message"`
+	GitBranch   string          `json:"gitBranch"`
+	Cwd         string          `json:"cwd"`
+	RequestID   string          `json:"requestId"`
+	IsMeta      bool            `json:"isMeta"`
+	IsSidechain bool            `json:"isSidechain"`
+	SessionID   string          `json:"sessionId"`
+	AgentID     string          `json:"agentId"`
+
+	// Summary, system and compaction entries
+	Summary          string          `json:"summary"`
+	LeafUUID         string          `json:"leafUuid"`
+	Subtype          string          `json:"subtype"`
+	Content          string          `json:"content"`
+	Level            string          `json:"level"`
+	ToolUseID        string          `json:"toolUseID"`
+	IsCompactSummary bool            `json:"isCompactSummary"`
+	CompactMetadata  json.RawMessage `json:"compactMetadata"`
 }
 
 // MessageContent represents the message field structure.
@@ -59,16 +96,115 @@ type MessageContent struct {
 	Text    string         `json:"text"`
 	Content []ContentBlock `json:"content"`
 	Model   string         `json:"model"` // Model used for this response (e.g., "claude-opus-4-5-20251101")
+	Usage   *Usage         `json:"usage"`
+}
+
+// UnmarshalJSON accepts content as a block array or, as Claude Code writes
+// typed prompts, a plain string, which becomes a single text block.
+func (mc *MessageContent) UnmarshalJSON(data []byte) error {
+	type plainMessage MessageContent
+	var raw struct {
+		plainMessage
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*mc = MessageContent(raw.plainMessage)
+	if len(raw.Content) == 0 || string(raw.Content) == "null" {
+		return nil
+	}
+
+	var str string
+	if err := json.Unmarshal(raw.Content, &str); err == nil {
+		if str != "" {
+			mc.Content = []ContentBlock{{Type: "text", Text: str}}
+		}
+		return nil
+	}
+	return json.Unmarshal(raw.Content, &mc.Content)
+}
+
+// Usage represents token accounting reported on assistant messages.
+type Usage struct {
+	InputTokens              int    `json:"input_tokens"`
+	OutputTokens             int    `json:"output_tokens"`
+	CacheCreationInputTokens int    `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int    `json:"cache_read_input_tokens"`
+	ServiceTier              string `json:"service_tier"`
+}
+
+// Details converts usage into Langfuse usageDetails, keeping cache reads and
+// cache writes in their own buckets so they can be priced separately.
+func (u *Usage) Details() map[string]int {
+	details := map[string]int{
+		"input":  u.InputTokens,
+		"output": u.OutputTokens,
+	}
+	if u.CacheReadInputTokens > 0 {
+		details["input_cache_read"] = u.CacheReadInputTokens
+	}
+	if u.CacheCreationInputTokens > 0 {
+		details["input_cache_creation"] = u.CacheCreationInputTokens
+	}
+	return details
 }
 
 // ContentBlock represents a content block in a message.
 type ContentBlock struct {
 	Type      string          `json:"type"`
 	Text      string          `json:"text"`
+	ID        string          `json:"id"`
 	Name      string          `json:"name"`
 	Input     json.RawMessage `json:"input"`
 	Content   string          `json:"content"`
 	ToolUseID string          `json:"tool_use_id"`
+	IsError   bool            `json:"is_error"`
+	Thinking  string          `json:"thinking"`
+	Source    *MediaSource    `json:"source"`
+
+	// RawContent holds the tool_result content as sent, which may be a
+	// string or an array of content blocks.
+	RawContent json.RawMessage `json:"-"`
+}
+
+// UnmarshalJSON accepts tool_result content as either a string or an array
+// of content blocks, flattening the text of the latter into Content.
+func (b *ContentBlock) UnmarshalJSON(data []byte) error {
+	type plainBlock ContentBlock
+	var raw struct {
+		plainBlock
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*b = ContentBlock(raw.plainBlock)
+	if len(raw.Content) == 0 || string(raw.Content) == "null" {
+		return nil
+	}
+	b.RawContent = raw.Content
+
+	var str string
+	if err := json.Unmarshal(raw.Content, &str); err == nil {
+		b.Content = str
+		return nil
+	}
+
+	var nested []ContentBlock
+	if err := json.Unmarshal(raw.Content, &nested); err == nil {
+		var parts []string
+		for _, block := range nested {
+			if block.Text != "" {
+				parts = append(parts, block.Text)
+			}
+		}
+		b.Content = strings.Join(parts, "\n\n")
+	}
+
+	return nil
 }
 
 // New creates a new Monitor.
@@ -85,8 +221,32 @@ func New(opts Options) (*Monitor, error) {
 		conversationSessions: make(map[string]string),
 	}
 
+	m.redactor, err = newRedactor(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	if !opts.DryRun {
-		m.client = langfuse.NewClient(cfg.Host, cfg.PublicKey, cfg.SecretKey)
+		dest, _ := cfg.Destination(config.DefaultDestination)
+		client, err := newLangfuseClient(cfg, dest, config.DefaultDestination)
+		if err != nil {
+			return nil, err
+		}
+		m.client = client
+		if m.clients, err = newClients(cfg); err != nil {
+			return nil, err
+		}
+		if m.sinks, err = newSinks(cfg); err != nil {
+			return nil, err
+		}
+		m.attachArchives()
+
+		st, err := state.Open(state.DefaultFile())
+		if err != nil {
+			return nil, fmt.Errorf("failed to load state: %w", err)
+		}
+		m.state = st
+		m.expireMessages()
 	}
 
 	return m, nil
@@ -123,12 +283,17 @@ func (m *Monitor) ProcessExistingHistory() error {
 	cutoffTime := time.Now().Add(-time.Duration(m.options.HistoryHours) * time.Hour)
 
 	var conversations []string
+	unchanged := 0
 	err = filepath.Walk(projectsDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return nil // Skip errors
 		}
 		if !info.IsDir() && strings.HasSuffix(path, ".jsonl") {
 			if info.ModTime().After(cutoffTime) {
+				if m.checkpointCurrent(path, info) {
+					unchanged++
+					return nil
+				}
 				conversations = append(conversations, path)
 			}
 		}
@@ -139,7 +304,15 @@ func (m *Monitor) ProcessExistingHistory() error {
 		return fmt.Errorf("failed to scan conversations: %w", err)
 	}
 
-	gray.Printf("  Found %d recent conversations\n", len(conversations))
+	// Sub-agent files come after the conversations whose Task calls spawn them
+	sort.SliceStable(conversations, func(i, j int) bool {
+		return !isAgentFile(conversations[i]) && isAgentFile(conversations[j])
+	})
+
+	gray.Printf("  Found %d recent conversations\n", len(conversations)+unchanged)
+	if unchanged > 0 {
+		gray.Printf("  Skipping %d unchanged since last checkpoint\n", unchanged)
+	}
 
 	for _, filepath := range conversations {
 		m.ProcessConversationFile(filepath)
@@ -169,53 +342,115 @@ func (m *Monitor) ProcessConversationFile(filepath string) {
 		return
 	}
 
+	// The encoded directory name is ambiguous ("my-app" decodes to "my/app"),
+	// so it seeds the session ID, keeping session IDs stable across
+	// versions, and is the project only for files whose messages record no
+	// cwd. Until the first message is written the file is left unread, so
+	// that summaries are never sent for a project that opted out.
 	encodedProject := parts[projectsIdx+1]
 	projectPath := strings.ReplaceAll(encodedProject, "-", "/")
 	conversationID := strings.TrimSuffix(parts[len(parts)-1], ".jsonl")
 
+	project, ok := m.projectFor(filepath, projectPath)
+	if !ok {
+		return
+	}
+	tracked := m.isTracked(project)
+
 	// Get or create session ID
 	m.mu.Lock()
 	sessionID, exists := m.conversationSessions[filepath]
 	if !exists {
-		sessionData := fmt.Sprintf("%s:%s", projectPath, conversationID)
-		hash := md5.Sum([]byte(sessionData))
-		sessionID = hex.EncodeToString(hash[:])
+		sessionID = sessionHash(projectPath, conversationID)
 		m.conversationSessions[filepath] = sessionID
 	}
 	m.mu.Unlock()
 
-	// Read and process messages
-	file, err := os.Open(filepath)
+	// Read and process messages appended since the last pass
+	err := m.tailFile(filepath, func(entry *Entry) {
+		if !tracked {
+			return
+		}
+
+		// Sub-agent files belong to the session of the parent conversation
+		if entry.IsSidechain && entry.SessionID != "" && entry.SessionID != conversationID {
+			m.ProcessMessage(entry, sessionHash(projectPath, entry.SessionID), project, entry.SessionID)
+			return
+		}
+		m.ProcessMessage(entry, sessionID, project, conversationID)
+	})
 	if err != nil {
 		color.Red("Error reading %s: %v", filepath, err)
-		return
 	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	// Increase buffer size for large lines
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 1024*1024) // 1MB max line size
+}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
+// isAgentFile reports whether path holds a sub-agent transcript.
+func isAgentFile(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), "agent-")
+}
 
-		var entry Entry
-		if err := json.Unmarshal([]byte(line), &entry); err != nil {
-			continue // Skip invalid JSON lines
-		}
+// sessionHash derives the Langfuse session ID of a conversation.
+func sessionHash(projectPath, conversationID string) string {
+	sessionData := fmt.Sprintf("%s:%s", projectPath, conversationID)
+	hash := md5.Sum([]byte(sessionData))
+	return hex.EncodeToString(hash[:])
+}
 
-		m.ProcessMessage(&entry, sessionID, projectPath, conversationID)
+// checkpointCurrent reports whether the file has been fully ingested and
+// nothing has been appended since.
+func (m *Monitor) checkpointCurrent(path string, info os.FileInfo) bool {
+	if m.state == nil {
+		return false
 	}
+	fs, ok := m.state.File(path)
+	if !ok {
+		return false
+	}
+	if inode := fileInode(info); fs.Inode != 0 && inode != fs.Inode {
+		return false
+	}
+	return fs.Offset == info.Size()
 }
 
 // ProcessMessage processes a single message entry.
 func (m *Monitor) ProcessMessage(entry *Entry, sessionID, projectPath, conversationID string) {
 	msgType := entry.Type
 
+	// Projects in metadata-only mode never send their content
+	private := m.metadataOnly(projectPath)
+	if private {
+		entry = maskEntry(entry)
+	}
+
+	// Parse timestamp
+	timestamp := time.Now()
+	if entry.Timestamp != "" {
+		if t, err := time.Parse(time.RFC3339, entry.Timestamp); err == nil {
+			timestamp = t
+		}
+	}
+
+	// Every entry joins the parentUuid chain, even ones that are not sent.
+	// Sub-agent traffic nests under the Task call that spawned it instead.
+	isPrompt := m.isHumanPrompt(entry)
+	convKey := sessionID
+	var agent *agentRun
+	var traceID string
+	if entry.IsSidechain {
+		isPrompt = false
+		agent = m.resolveAgent(entry, sessionID, timestamp)
+		convKey = agent.key()
+		traceID = agent.traceID
+	} else {
+		traceID = m.assignTurn(entry, sessionID, isPrompt, timestamp)
+	}
+	m.recordHistory(entry, convKey, isPrompt)
+
+	if isEventEntry(entry) {
+		m.processEvent(entry, sessionID, traceID, projectPath, conversationID, timestamp)
+		return
+	}
+
 	if msgType != "user" && msgType != "assistant" {
 		return
 	}
@@ -225,9 +460,9 @@ func (m *Monitor) ProcessMessage(entry *Entry, sessionID, projectPath, conversat
 		return
 	}
 
-	// Check deduplication
+	// Check deduplication, including messages sent by previous runs
 	m.mu.Lock()
-	if m.processedMessages[uuid] {
+	if m.processedMessages[uuid] || (m.state != nil && m.state.IsSent(uuid)) {
 		m.mu.Unlock()
 		return
 	}
@@ -237,14 +472,6 @@ func (m *Monitor) ProcessMessage(entry *Entry, sessionID, projectPath, conversat
 	// Extract message content
 	text := m.extractContent(entry.Message)
 
-	// Parse timestamp
-	timestamp := time.Now()
-	if entry.Timestamp != "" {
-		if t, err := time.Parse(time.RFC3339, entry.Timestamp); err == nil {
-			timestamp = t
-		}
-	}
-
 	// Track message counts
 	m.mu.Lock()
 	if msgType == "user" {
@@ -266,6 +493,8 @@ func (m *Monitor) ProcessMessage(entry *Entry, sessionID, projectPath, conversat
 		icon := "[user]"
 		if msgType == "assistant" {
 			icon = "[assistant]"
+		} else if !isPrompt {
+			icon = "[tool]"
 		}
 		gray := color.New(color.FgHiBlack)
 		gray.Printf("%s [%s] %s...\n", icon, projectName, preview)
@@ -275,8 +504,12 @@ func (m *Monitor) ProcessMessage(entry *Entry, sessionID, projectPath, conversat
 		return
 	}
 
-	// Create trace in Langfuse
-	if msgType == "user" {
+	if agent != nil {
+		m.startAgentSpan(agent, projectPath, entry.AgentID)
+	}
+
+	// Human prompts start a new trace; everything else joins the current turn
+	if msgType == "user" && isPrompt {
 		trace := &langfuse.Trace{
 			ID:        uuid,
 			Name:      m.config.UserTraceName,
@@ -291,37 +524,60 @@ func (m *Monitor) ProcessMessage(entry *Entry, sessionID, projectPath, conversat
 				"source":         m.config.Source,
 			},
 			Input:     text,
-			Timestamp: timestamp,
+			Timestamp: &timestamp,
 		}
-		if err := m.client.CreateTrace(trace); err != nil {
+		if summary := m.conversationSummary(sessionID); summary != "" {
+			trace.Metadata["conversationSummary"] = summary
+		}
+		if private {
+			trace.Metadata["metadataOnly"] = true
+		}
+		if err := m.createTrace(projectPath, trace); err != nil {
 			color.Red("Error creating trace: %v", err)
 		}
+		if media := mediaBlocks(m.extractBlocks(entry.Message)); len(media) > 0 {
+			m.attachMedia(projectPath, media, uuid, text)
+		}
+		m.setCurrentTrace(sessionID, trace)
+		m.finishToolSpans(entry.Message, projectPath, traceID, timestamp)
+		m.markSent(uuid)
+	} else if msgType == "user" {
+		m.finishToolSpans(entry.Message, projectPath, traceID, timestamp)
+		m.markSent(uuid)
 	} else if msgType == "assistant" {
-		// Extract model from message if available, fallback to config
-		model := m.extractModel(entry.Message)
-		if model == "" {
-			model = m.config.Model
+		// Streamed chunks of one response share a requestId and are merged
+		parentID := ""
+		if agent != nil {
+			parentID = agent.id
 		}
-
-		gen := &langfuse.Generation{
-			ID:      uuid,
-			TraceID: entry.ParentUUID,
-			Name:    m.config.AssistantTraceName,
-			Model:   model,
-			Metadata: map[string]interface{}{
-				"project":        projectPath,
-				"conversationId": conversationID,
-				"requestId":      entry.RequestID,
-				"messageType":    msgType,
-				"source":         m.config.Source,
-			},
-			Output:    text,
-			StartTime: timestamp,
-			EndTime:   timestamp,
+		gen, isNew := m.mergeGeneration(entry, convKey, traceID, parentID, projectPath, conversationID, timestamp)
+		if isNew {
+			if err := m.createGeneration(projectPath, gen); err != nil {
+				color.Red("Error creating generation: %v", err)
+			}
+		} else {
+			if err := m.updateGeneration(projectPath, gen); err != nil {
+				color.Red("Error updating generation: %v", err)
+			}
 		}
-		if err := m.client.CreateGeneration(gen); err != nil {
-			color.Red("Error creating generation: %v", err)
+		m.startToolSpans(entry.Message, projectPath, gen, sessionID, timestamp)
+		m.recordThinking(entry, projectPath, gen, timestamp)
+		output := m.generationText(convKey, entry.RequestID, entry.Message)
+		if agent != nil {
+			if err := m.updateAgentOutput(agent, projectPath, output, timestamp); err != nil {
+				color.Red("Error updating agent span: %v", err)
+			}
+		} else if err := m.updateTurnOutput(projectPath, sessionID, traceID, output); err != nil {
+			color.Red("Error updating trace: %v", err)
 		}
+		m.markSent(uuid)
+	}
+}
+
+// markSent records a message in the checkpoint store.
+func (m *Monitor) markSent(uuid string) {
+	if m.state != nil {
+		m.state.MarkSent(uuid)
 	}
 }
 
@@ -345,6 +601,20 @@ func (m *Monitor) extractModel(rawMessage json.RawMessage) string {
 	return ""
 }
 
+// extractUsage extracts token usage from the message field.
+func (m *Monitor) extractUsage(rawMessage json.RawMessage) *Usage {
+	if len(rawMessage) == 0 {
+		return nil
+	}
+
+	var msgContent MessageContent
+	if err := json.Unmarshal(rawMessage, &msgContent); err != nil {
+		return nil
+	}
+
+	return msgContent.Usage
+}
+
 // extractContent extracts text from the message field.
 func (m *Monitor) extractContent(rawMessage json.RawMessage) string {
 	if len(rawMessage) == 0 {
@@ -364,39 +634,8 @@ func (m *Monitor) extractContent(rawMessage json.RawMessage) string {
 	}
 
 	// If content array exists, process it
-	if len(msgContent.Content) > 0 {
-		var parts []string
-		for _, block := range msgContent.Content {
-			switch block.Type {
-			case "text":
-				if block.Text != "" {
-					parts = append(parts, block.Text)
-				}
-			case "tool_use":
-				inputStr := ""
-				if len(block.Input) > 0 {
-					var prettyInput bytes.Buffer
-					if json.Indent(&prettyInput, block.Input, "", "  ") == nil {
-						inputStr = prettyInput.String()
-					} else {
-						inputStr = string(block.Input)
-					}
-				}
-				parts = append(parts, fmt.Sprintf("[Tool: %s]\n%s", block.Name, inputStr))
-			case "tool_result":
-				if block.Content != "" {
-					parts = append(parts, block.Content)
-				}
-			default:
-				// Handle text field on block directly
-				if block.Text != "" {
-					parts = append(parts, block.Text)
-				}
-			}
-		}
-		if len(parts) > 0 {
-			return strings.Join(parts, "\n\n")
-		}
+	if text := formatBlocks(msgContent.Content); text != "" {
+		return text
 	}
 
 	// Fallback to text field
@@ -407,18 +646,129 @@ func (m *Monitor) extractContent(rawMessage json.RawMessage) string {
 	return ""
 }
 
-// Shutdown stops the monitor and flushes pending events.
-func (m *Monitor) Shutdown() error {
-	if m.client != nil {
-		return m.client.Shutdown()
+// formatBlocks flattens content blocks into newline-separated text.
+func formatBlocks(blocks []ContentBlock) string {
+	var parts []string
+	for _, block := range blocks {
+		switch block.Type {
+		case "text":
+			if block.Text != "" {
+				parts = append(parts, block.Text)
+			}
+		case "tool_use":
+			inputStr := ""
+			if len(block.Input) > 0 {
+				var prettyInput bytes.Buffer
+				if json.Indent(&prettyInput, block.Input, "", "  ") == nil {
+					inputStr = prettyInput.String()
+				} else {
+					inputStr = string(block.Input)
+				}
+			}
+			parts = append(parts, fmt.Sprintf("[Tool: %s]\n%s", block.Name, inputStr))
+		case "tool_result":
+			if block.Content != "" {
+				parts = append(parts, block.Content)
+			}
+		default:
+			// Handle text field on block directly
+			if block.Text != "" {
+				parts = append(parts, block.Text)
+			}
+		}
 	}
-	return nil
+	return strings.Join(parts, "\n\n")
 }
 
-// Flush sends any pending events to Langfuse.
+// Shutdown stops the monitor and flushes pending events, giving up when
+// ctx expires. Every output is shut down even after a timeout, so that each
+// can write out or spool what it still holds; checkpoints are then left
+// unsaved.
+func (m *Monitor) Shutdown(ctx context.Context) error {
+	pending := m.waitMedia(ctx)
+	if m.stopFlush != nil {
+		close(m.stopFlush)
+		select {
+		case <-m.flushDone:
+		case <-ctx.Done():
+			if pending == nil {
+				pending = fmt.Errorf("events still pending at shutdown: %w", ctx.Err())
+			}
+		}
+	}
+
+	err := m.forEachOutput(func(o output) error {
+		return o.Shutdown(ctx)
+	})
+	if pending != nil {
+		return pending
+	}
+	if err != nil {
+		return err
+	}
+	return m.saveState()
+}
+
+// Start flushes the sinks once per flush interval until Shutdown, saving
+// checkpoints when every required output has delivered. Langfuse clients
+// send on their own interval and only report whether they are done.
+func (m *Monitor) Start() {
+	m.stopFlush = make(chan struct{})
+	m.flushDone = make(chan struct{})
+	go func() {
+		defer close(m.flushDone)
+		ticker := time.NewTicker(m.config.FlushInterval())
+		defer ticker.Stop()
+		lastExpire := time.Now()
+		for {
+			select {
+			case <-ticker.C:
+				if time.Since(lastExpire) >= expireInterval {
+					m.expireMessages()
+					lastExpire = time.Now()
+				}
+				if m.flushSinks() == nil && m.delivered() {
+					m.saveState()
+				}
+			case <-m.stopFlush:
+				return
+			}
+		}
+	}()
+}
+
+// Flush sends any pending events to every destination and sink.
+// Checkpoints are only persisted once all required outputs have succeeded.
 func (m *Monitor) Flush() error {
-	if m.client != nil {
-		return m.client.Flush()
+	if err := m.forEachOutput(output.Flush); err != nil {
+		return err
+	}
+	return m.saveState()
+}
+
+// messageRetention is how long sent message UUIDs are remembered beyond the
+// history window. Older messages are covered by their file's checkpoint.
+const messageRetention = 24 * time.Hour
+
+// expireInterval is how often a running monitor forgets old message UUIDs.
+const expireInterval = time.Hour
+
+// expireMessages drops message checkpoints older than the history window
+// plus messageRetention, so the state file does not grow without bound.
+func (m *Monitor) expireMessages() {
+	if m.state == nil {
+		return
+	}
+	m.state.ExpireMessages(time.Duration(m.options.HistoryHours)*time.Hour + messageRetention)
+}
+
+// saveState persists the checkpoint store.
+func (m *Monitor) saveState() error {
+	if m.state == nil {
+		return nil
+	}
+	if err := m.state.Save(); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
 	}
 	return nil
 }
@@ -434,4 +784,3 @@ func (m *Monitor) MessageStats() (user, assistant int) {
 	defer m.mu.Unlock()
 	return m.messageCount.user, m.messageCount.assistant
 }
-
@@ -0,0 +1,104 @@
+package monitor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// metadataOnly reports whether content from the project must be hashed.
+func (m *Monitor) metadataOnly(projectPath string) bool {
+	return m.config != nil && m.config.IsMetadataOnly(projectPath)
+}
+
+// digest replaces content with its SHA-256 hash and length.
+func digest(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return fmt.Sprintf("[sha256:%s length:%d]", hex.EncodeToString(sum[:]), len(content))
+}
+
+// maskEntry returns a copy of the entry with all content replaced by
+// digests. Message structure, block types, tool names, model and usage are
+// kept so that timing and usage telemetry is unaffected.
+func maskEntry(entry *Entry) *Entry {
+	masked := *entry
+	masked.Message = maskMessage(entry.Message)
+	if entry.Summary != "" {
+		masked.Summary = digest(entry.Summary)
+	}
+	if entry.Content != "" {
+		masked.Content = digest(entry.Content)
+	}
+	return &masked
+}
+
+// maskMessage hashes the text, thinking, tool input and tool result content
+// of a message field.
+func maskMessage(rawMessage json.RawMessage) json.RawMessage {
+	if len(rawMessage) == 0 {
+		return rawMessage
+	}
+
+	var message interface{}
+	if err := json.Unmarshal(rawMessage, &message); err != nil {
+		return nil
+	}
+
+	switch msg := message.(type) {
+	case string:
+		message = digest(msg)
+	case map[string]interface{}:
+		if text, ok := msg["text"].(string); ok {
+			msg["text"] = digest(text)
+		}
+		if content, ok := msg["content"]; ok {
+			msg["content"] = maskContent(content)
+		}
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// maskContent hashes a content string or array of content blocks.
+func maskContent(content interface{}) interface{} {
+	switch c := content.(type) {
+	case string:
+		return digest(c)
+	case []interface{}:
+		for _, item := range c {
+			if block, ok := item.(map[string]interface{}); ok {
+				maskBlock(block)
+			}
+		}
+	}
+	return content
+}
+
+// maskBlock hashes the content fields of a single content block in place.
+func maskBlock(block map[string]interface{}) {
+	for _, key := range []string{"text", "thinking", "data"} {
+		if s, ok := block[key].(string); ok {
+			block[key] = digest(s)
+		}
+	}
+	if input, ok := block["input"]; ok {
+		data, _ := json.Marshal(input)
+		block["input"] = digest(string(data))
+	}
+	if content, ok := block["content"]; ok {
+		block["content"] = maskContent(content)
+	}
+	if source, ok := block["source"].(map[string]interface{}); ok {
+		data, _ := source["data"].(string)
+		block["source"] = map[string]interface{}{
+			"type":       "digest",
+			"media_type": source["media_type"],
+			"data":       digest(data),
+		}
+	}
+}
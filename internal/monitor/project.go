@@ -0,0 +1,195 @@
+package monitor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// ignoreFile opts a project out of tracking when present in its root.
+const ignoreFile = ".claude-langfuse-ignore"
+
+// optOutTTL is how long an opt-out check is cached, so that adding or
+// removing an ignore file takes effect without a restart.
+const optOutTTL = 30 * time.Second
+
+// optOut is a cached opt-out check for a project.
+type optOut struct {
+	ignored   bool
+	checkedAt time.Time
+}
+
+// projectFor returns the project a conversation file belongs to. It is
+// derived from the cwd of the first message in the file, grouped by git
+// repository, or is fallback (the decoded project directory name) if that
+// message records no cwd, as in files written by older Claude Code versions.
+// Summaries carry no cwd, so ok is false until a message has been written;
+// lines already scanned are not read again.
+func (m *Monitor) projectFor(path, fallback string) (project string, ok bool) {
+	m.mu.Lock()
+	project, ok = m.projects[path]
+	from := m.cwdScans[path]
+	m.mu.Unlock()
+	if ok {
+		return project, true
+	}
+
+	cwd, found, next := scanCwd(path, from)
+	if found {
+		project = fallback
+		if cwd != "" {
+			project = m.resolveProject(cwd)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !found {
+		if m.cwdScans == nil {
+			m.cwdScans = make(map[string]int64)
+		}
+		m.cwdScans[path] = next
+		return "", false
+	}
+	if m.projects == nil {
+		m.projects = make(map[string]string)
+	}
+	m.projects[path] = project
+	delete(m.cwdScans, path)
+	return project, true
+}
+
+// scanCwd reads a conversation file from offset until the first message,
+// and returns its cwd, whether a message was found and the offset of the
+// first line not yet scanned. A file shorter than offset is scanned again
+// from the start.
+func scanCwd(path string, offset int64) (cwd string, found bool, next int64) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", false, offset
+	}
+	defer file.Close()
+
+	if info, err := file.Stat(); err != nil || info.Size() < offset {
+		offset = 0
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return "", false, 0
+	}
+
+	reader := bufio.NewReaderSize(file, 64*1024)
+	for {
+		line, err := reader.ReadBytes('\n')
+		var entry struct {
+			UUID string `json:"uuid"`
+			Cwd  string `json:"cwd"`
+		}
+		if json.Unmarshal(bytes.TrimSpace(line), &entry) == nil && (entry.UUID != "" || entry.Cwd != "") {
+			return entry.Cwd, true, offset + int64(len(line))
+		}
+		if err != nil {
+			// Leave a partial last line for the next scan
+			return "", false, offset
+		}
+		offset += int64(len(line))
+	}
+}
+
+// resolveProject maps a working directory to the root of the git repository
+// containing it, so sessions started in subdirectories share one project.
+func (m *Monitor) resolveProject(cwd string) string {
+	cwd = filepath.Clean(cwd)
+
+	m.mu.Lock()
+	root, ok := m.gitRoots[cwd]
+	m.mu.Unlock()
+	if ok {
+		return root
+	}
+
+	root = findGitRoot(cwd)
+	if root == "" {
+		root = cwd
+	}
+
+	m.mu.Lock()
+	if m.gitRoots == nil {
+		m.gitRoots = make(map[string]string)
+	}
+	m.gitRoots[cwd] = root
+	m.mu.Unlock()
+
+	return root
+}
+
+// findGitRoot walks up from dir to the nearest directory containing .git
+// (a directory, or a file for worktrees and submodules).
+func findGitRoot(dir string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// isTracked reports whether entries from the project should be sent, based
+// on the configured include/exclude globs and the project's own opt-out.
+func (m *Monitor) isTracked(projectPath string) bool {
+	if m.config != nil && !m.config.IsProjectIncluded(projectPath) {
+		return false
+	}
+
+	m.mu.Lock()
+	cached, ok := m.optOuts[projectPath]
+	m.mu.Unlock()
+	if ok && time.Since(cached.checkedAt) < optOutTTL {
+		return !cached.ignored
+	}
+
+	ignored := projectOptedOut(projectPath)
+	if ignored && !cached.ignored && !m.options.Quiet {
+		color.New(color.FgHiBlack).Printf("Skipping %s (opted out of Langfuse tracking)\n", projectPath)
+	}
+
+	m.mu.Lock()
+	if m.optOuts == nil {
+		m.optOuts = make(map[string]optOut)
+	}
+	m.optOuts[projectPath] = optOut{ignored: ignored, checkedAt: time.Now()}
+	m.mu.Unlock()
+
+	return !ignored
+}
+
+// projectOptedOut reports whether the project root contains an ignore file
+// or Claude settings with "langfuse": false.
+func projectOptedOut(projectPath string) bool {
+	if _, err := os.Stat(filepath.Join(projectPath, ignoreFile)); err == nil {
+		return true
+	}
+
+	for _, name := range []string{"settings.json", "settings.local.json"} {
+		data, err := os.ReadFile(filepath.Join(projectPath, ".claude", name))
+		if err != nil {
+			continue
+		}
+		var settings struct {
+			Langfuse *bool `json:"langfuse"`
+		}
+		if err := json.Unmarshal(data, &settings); err == nil && settings.Langfuse != nil && !*settings.Langfuse {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/user/claude-langfuse-go/internal/config"
+)
+
+// detectorPatterns are the built-in redaction detectors.
+var detectorPatterns = map[string]string{
+	config.DetectorAWS:        `\b(?:AKIA|ASIA)[0-9A-Z]{16}\b|(?i:aws_secret_access_key)["']?\s*[:=]\s*["']?[A-Za-z0-9/+=]{40}`,
+	config.DetectorGitHub:     `\b(?:gh[pousr]_[A-Za-z0-9]{36,255}|github_pat_[A-Za-z0-9_]{22,255})\b`,
+	config.DetectorJWT:        `\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`,
+	config.DetectorPrivateKey: `(?s)-----BEGIN [A-Z ]*PRIVATE KEY-----.*?-----END [A-Z ]*PRIVATE KEY-----`,
+	config.DetectorEmail:      `\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`,
+}
+
+// redactRule masks matches of a pattern with a labelled placeholder.
+type redactRule struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+// redactor masks secrets and personal data in outgoing events.
+type redactor struct {
+	rules []redactRule
+}
+
+// newRedactor builds a redactor from the configured detectors and patterns.
+// It returns nil when redaction is disabled or no rules are configured.
+func newRedactor(cfg *config.Config) (*redactor, error) {
+	if cfg == nil || cfg.DisableRedaction {
+		return nil, nil
+	}
+
+	r := &redactor{}
+	for _, name := range cfg.RedactDetectors {
+		pattern, ok := detectorPatterns[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown redaction detector %q", name)
+		}
+		r.rules = append(r.rules, redactRule{name: name, pattern: regexp.MustCompile(pattern)})
+	}
+	for _, pattern := range cfg.RedactPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", pattern, err)
+		}
+		r.rules = append(r.rules, redactRule{name: "custom", pattern: re})
+	}
+
+	if len(r.rules) == 0 {
+		return nil, nil
+	}
+	return r, nil
+}
+
+// redactString masks all rule matches in s and returns the number masked.
+func (r *redactor) redactString(s string) (string, int) {
+	count := 0
+	for _, rule := range r.rules {
+		s = rule.pattern.ReplaceAllStringFunc(s, func(string) string {
+			count++
+			return "[REDACTED:" + rule.name + "]"
+		})
+	}
+	return s, count
+}
+
+// redactValue returns a redacted copy of v. Structured values are
+// normalised through JSON so that shared slices and structs (e.g. chat
+// history) are never modified in place.
+func (r *redactor) redactValue(v interface{}) (interface{}, int) {
+	switch val := v.(type) {
+	case nil:
+		return nil, 0
+	case string:
+		return r.redactString(val)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		total := 0
+		for k, item := range val {
+			redacted, n := r.redactValue(item)
+			out[k] = redacted
+			total += n
+		}
+		return out, total
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		total := 0
+		for i, item := range val {
+			redacted, n := r.redactValue(item)
+			out[i] = redacted
+			total += n
+		}
+		return out, total
+	case bool, int, int64, float64:
+		return val, 0
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v, 0
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return v, 0
+	}
+	return r.redactValue(generic)
+}
+
+// redactFields redacts input, output, metadata and any further text fields
+// (names, status messages) in place, recording the number of masked values
+// in metadata.
+func (r *redactor) redactFields(input, output *interface{}, metadata *map[string]interface{}, texts ...*string) {
+	if r == nil {
+		return
+	}
+
+	var total, n int
+	*input, n = r.redactValue(*input)
+	total += n
+	*output, n = r.redactValue(*output)
+	total += n
+	for _, text := range texts {
+		*text, n = r.redactString(*text)
+		total += n
+	}
+
+	if *metadata != nil {
+		redacted, n := r.redactValue(*metadata)
+		*metadata = redacted.(map[string]interface{})
+		total += n
+	}
+
+	if total > 0 {
+		if *metadata == nil {
+			*metadata = make(map[string]interface{})
+		}
+		(*metadata)["redactions"] = total
+	}
+}
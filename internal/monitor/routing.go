@@ -0,0 +1,155 @@
+package monitor
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/user/claude-langfuse-go/internal/config"
+)
+
+// newClients creates a client for every destination referenced by a route.
+// The default destination is served by Monitor.client and is not included.
+func newClients(cfg *config.Config) (map[string]Exporter, error) {
+	clients := make(map[string]Exporter)
+	for _, route := range cfg.Routes {
+		name := route.Destination
+		if name == config.DefaultDestination || clients[name] != nil {
+			continue
+		}
+		dest, ok := cfg.Destination(name)
+		if !ok {
+			return nil, fmt.Errorf("route refers to unknown destination %q", name)
+		}
+		client, err := newLangfuseClient(cfg, dest, name)
+		if err != nil {
+			return nil, err
+		}
+		clients[name] = client
+	}
+	return clients, nil
+}
+
+// errNoProject is returned for events whose project is unknown while
+// projects are routed, rather than sending them to the default destination.
+var errNoProject = errors.New("event has no project to route by")
+
+// destinationFor returns the name of the destination a project routes to,
+// or "" for an empty project when routes are configured.
+func (m *Monitor) destinationFor(projectPath string) string {
+	if m.config == nil || len(m.config.Routes) == 0 {
+		return config.DefaultDestination
+	}
+	if projectPath == "" {
+		return ""
+	}
+
+	m.mu.Lock()
+	dest, ok := m.projectRoutes[projectPath]
+	m.mu.Unlock()
+	if ok {
+		return dest
+	}
+
+	dest = m.config.Route(projectPath, gitRemotes(projectPath))
+
+	m.mu.Lock()
+	if m.projectRoutes == nil {
+		m.projectRoutes = make(map[string]string)
+	}
+	m.projectRoutes[projectPath] = dest
+	m.mu.Unlock()
+
+	return dest
+}
+
+// clientFor returns the client of the destination a project routes to. It
+// returns errNoProject for an empty project when routes are configured.
+func (m *Monitor) clientFor(projectPath string) (Exporter, error) {
+	if len(m.clients) == 0 {
+		return m.client, nil
+	}
+	if projectPath == "" {
+		return nil, errNoProject
+	}
+	if client := m.clients[m.destinationFor(projectPath)]; client != nil {
+		return client, nil
+	}
+	return m.client, nil
+}
+
+// allClients returns the default client followed by the routed ones.
+func (m *Monitor) allClients() []Exporter {
+	var clients []Exporter
+	if m.client != nil {
+		clients = append(clients, m.client)
+	}
+
+	names := make([]string, 0, len(m.clients))
+	for name := range m.clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		clients = append(clients, m.clients[name])
+	}
+	return clients
+}
+
+// gitRemotes returns the remote URLs configured for the repository at
+// projectPath, following the .git file of worktrees and submodules.
+func gitRemotes(projectPath string) []string {
+	gitDir := filepath.Join(projectPath, ".git")
+	info, err := os.Stat(gitDir)
+	if err != nil {
+		return nil
+	}
+
+	if !info.IsDir() {
+		data, err := os.ReadFile(gitDir)
+		if err != nil {
+			return nil
+		}
+		dir := strings.TrimSpace(strings.TrimPrefix(string(data), "gitdir:"))
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(projectPath, dir)
+		}
+		gitDir = dir
+		// Linked worktrees share the config of the main repository
+		if common, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+			dir := strings.TrimSpace(string(common))
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(gitDir, dir)
+			}
+			gitDir = dir
+		}
+	}
+
+	f, err := os.Open(filepath.Join(gitDir, "config"))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var remotes []string
+	inRemote := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") {
+			inRemote = strings.HasPrefix(line, "[remote ")
+			continue
+		}
+		if !inRemote {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok && strings.TrimSpace(key) == "url" {
+			remotes = append(remotes, strings.TrimSpace(value))
+		}
+	}
+	return remotes
+}
@@ -0,0 +1,125 @@
+package monitor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/user/claude-langfuse-go/internal/state"
+)
+
+// fileCursor tracks how far a conversation file has been consumed.
+// Its mutex serializes concurrent watcher callbacks for the same file.
+type fileCursor struct {
+	mu      sync.Mutex
+	loaded  bool
+	offset  int64
+	inode   uint64
+	size    int64
+	modTime time.Time
+}
+
+// cursor returns the cursor for a file, creating it if needed.
+func (m *Monitor) cursor(path string) *fileCursor {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cursors == nil {
+		m.cursors = make(map[string]*fileCursor)
+	}
+	cur, ok := m.cursors[path]
+	if !ok {
+		cur = &fileCursor{}
+		m.cursors[path] = cur
+	}
+	return cur
+}
+
+// tailFile reads the entries appended to path since the last call and passes
+// each one to fn. Partial trailing lines are left for the next call, and a
+// truncated or replaced file is re-read from the start.
+func (m *Monitor) tailFile(path string, fn func(*Entry)) error {
+	cur := m.cursor(path)
+	cur.mu.Lock()
+	defer cur.mu.Unlock()
+
+	if !cur.loaded {
+		if m.state != nil {
+			if fs, ok := m.state.File(path); ok {
+				cur.offset = fs.Offset
+				cur.inode = fs.Inode
+			}
+		}
+		cur.loaded = true
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	inode := fileInode(info)
+	if (cur.inode != 0 && inode != 0 && inode != cur.inode) || info.Size() < cur.offset {
+		cur.offset = 0
+	}
+	cur.inode = inode
+
+	if _, err := file.Seek(cur.offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReaderSize(file, 64*1024)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		complete := err == nil
+		if !complete {
+			// A trailing line without a newline may still be mid-flush;
+			// only consume it once it parses as a whole JSON value.
+			if len(bytes.TrimSpace(line)) == 0 || !json.Valid(line) {
+				break
+			}
+		}
+
+		cur.offset += int64(len(line))
+
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			var entry Entry
+			if json.Unmarshal(trimmed, &entry) == nil {
+				fn(&entry)
+			}
+			// Skip invalid JSON lines
+		}
+
+		if !complete {
+			break
+		}
+	}
+
+	cur.size = info.Size()
+	cur.modTime = info.ModTime()
+
+	if m.state != nil {
+		m.state.SetFile(path, state.FileState{
+			Offset:  cur.offset,
+			Size:    cur.size,
+			Inode:   cur.inode,
+			ModTime: cur.modTime,
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package monitor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/user/claude-langfuse-go/internal/langfuse"
+)
+
+// captureThinking reports whether extended thinking content may be sent.
+func (m *Monitor) captureThinking() bool {
+	return m.config == nil || !m.config.ExcludeThinking
+}
+
+// isThinking reports whether a block carries extended thinking.
+func isThinking(block ContentBlock) bool {
+	return block.Type == "thinking" || block.Type == "redacted_thinking"
+}
+
+// recordThinking emits each thinking block of an assistant entry as a child
+// span of its generation, keeping it apart from the answer text.
+func (m *Monitor) recordThinking(entry *Entry, projectPath string, gen *langfuse.Generation, timestamp time.Time) {
+	if !m.captureThinking() {
+		return
+	}
+
+	blocks := m.extractBlocks(entry.Message)
+
+	// Streamed chunks normally hold a single block, so usage reported on a
+	// thinking-only chunk can be attributed to the thinking itself.
+	thinkingOnly := len(blocks) > 0
+	for _, block := range blocks {
+		if !isThinking(block) {
+			thinkingOnly = false
+		}
+	}
+	usage := m.extractUsage(entry.Message)
+
+	for i, block := range blocks {
+		if !isThinking(block) {
+			continue
+		}
+
+		startTime := gen.StartTime
+		span := &langfuse.Span{
+			ID:                  fmt.Sprintf("%s-thinking-%d", entry.UUID, i),
+			TraceID:             gen.TraceID,
+			ParentObservationID: gen.ID,
+			Name:                "thinking",
+			Metadata: map[string]interface{}{
+				"source": m.config.Source,
+			},
+			StartTime: &startTime,
+			EndTime:   &timestamp,
+		}
+		if block.Type == "redacted_thinking" {
+			span.Metadata["redacted"] = true
+		} else {
+			span.Output = block.Thinking
+		}
+		if thinkingOnly && usage != nil && usage.OutputTokens > 0 {
+			span.Metadata["outputTokens"] = usage.OutputTokens
+		}
+
+		if err := m.createSpan(projectPath, span); err != nil {
+			color.Red("Error creating thinking span: %v", err)
+		}
+	}
+}
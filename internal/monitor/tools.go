@@ -0,0 +1,123 @@
+package monitor
+
+import (
+	"encoding/json"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+	"github.com/user/claude-langfuse-go/internal/langfuse"
+)
+
+// pendingTool is a tool_use span waiting for its tool_result.
+type pendingTool struct {
+	traceID   string
+	startTime time.Time
+}
+
+// extractBlocks returns the content blocks of the message field, if any.
+func (m *Monitor) extractBlocks(rawMessage json.RawMessage) []ContentBlock {
+	if len(rawMessage) == 0 {
+		return nil
+	}
+
+	var msgContent MessageContent
+	if err := json.Unmarshal(rawMessage, &msgContent); err != nil {
+		return nil
+	}
+
+	return msgContent.Content
+}
+
+// startToolSpans opens a span under the generation for every tool_use block.
+func (m *Monitor) startToolSpans(rawMessage json.RawMessage, projectPath string, gen *langfuse.Generation, sessionID string, timestamp time.Time) {
+	for _, block := range m.extractBlocks(rawMessage) {
+		if block.Type != "tool_use" || block.ID == "" {
+			continue
+		}
+
+		span := &langfuse.Span{
+			ID:                  block.ID,
+			TraceID:             gen.TraceID,
+			ParentObservationID: gen.ID,
+			Name:                block.Name,
+			Metadata: map[string]interface{}{
+				"toolUseId": block.ID,
+				"source":    m.config.Source,
+			},
+			StartTime: &timestamp,
+		}
+		if len(block.Input) > 0 {
+			span.Input = block.Input
+		}
+
+		m.mu.Lock()
+		if m.pendingTools == nil {
+			m.pendingTools = make(map[string]*pendingTool)
+		}
+		m.pendingTools[block.ID] = &pendingTool{traceID: gen.TraceID, startTime: timestamp}
+		m.mu.Unlock()
+
+		if isTaskTool(block.Name) {
+			m.registerTask(sessionID, block, gen.TraceID)
+		}
+
+		if err := m.createSpan(projectPath, span); err != nil {
+			color.Red("Error creating tool span: %v", err)
+		}
+	}
+}
+
+// finishToolSpans closes the span of every tool_use answered by a
+// tool_result block in the message. Spans not started in this run are
+// attributed to the trace of the current turn.
+func (m *Monitor) finishToolSpans(rawMessage json.RawMessage, projectPath, traceID string, timestamp time.Time) {
+	for _, block := range m.extractBlocks(rawMessage) {
+		if block.Type != "tool_result" || block.ToolUseID == "" {
+			continue
+		}
+
+		m.mu.Lock()
+		pending := m.pendingTools[block.ToolUseID]
+		delete(m.pendingTools, block.ToolUseID)
+		m.mu.Unlock()
+
+		span := &langfuse.Span{
+			ID:      block.ToolUseID,
+			TraceID: traceID,
+			Output:  toolOutput(block),
+			EndTime: &timestamp,
+		}
+		if pending != nil {
+			span.TraceID = pending.traceID
+			span.StartTime = &pending.startTime
+		}
+		if block.IsError {
+			span.Level = "ERROR"
+			span.StatusMessage = truncate(block.Content, 500)
+		}
+
+		if err := m.updateSpan(projectPath, span); err != nil {
+			color.Red("Error updating tool span: %v", err)
+		}
+	}
+}
+
+// toolOutput returns the tool result as structured JSON where possible.
+func toolOutput(block ContentBlock) interface{} {
+	if len(block.RawContent) > 0 {
+		return block.RawContent
+	}
+	return block.Content
+}
+
+// truncate shortens s to at most n bytes without splitting a UTF-8 rune.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,152 @@
+package monitor
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/user/claude-langfuse-go/internal/langfuse"
+)
+
+// conversation tracks how the entries of one session map onto turns. A turn
+// runs from one human prompt up to the next and becomes a single trace.
+type conversation struct {
+	turnOf      map[string]string // entry UUID -> trace ID of its turn
+	lastTurn    string
+	summary     string
+	lastInput   time.Time                     // timestamp of the latest prompt or tool result
+	current     *langfuse.Trace               // trace of the latest turn created in this run
+	generations map[string]*pendingGeneration // requestId -> generation of the current turn
+
+	// Chat format only: messages of the current turn so far, and responses
+	// not yet followed by a user entry.
+	history   []chatMessage
+	responses []*pendingGeneration
+
+	// Sub-agent runs by sidechain entry UUID and by "agent:<agentId>"
+	agents map[string]*agentRun
+}
+
+// conversationLocked returns the state for a session (m.mu must be held).
+func (m *Monitor) conversationLocked(sessionID string) *conversation {
+	if m.conversations == nil {
+		m.conversations = make(map[string]*conversation)
+	}
+	conv, ok := m.conversations[sessionID]
+	if !ok {
+		conv = &conversation{turnOf: make(map[string]string)}
+		m.conversations[sessionID] = conv
+	}
+	return conv
+}
+
+// assignTurn returns the trace ID for an entry by following its parentUuid
+// to the turn it belongs to. Human prompts start a new turn.
+func (m *Monitor) assignTurn(entry *Entry, sessionID string, isPrompt bool, timestamp time.Time) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conv := m.conversationLocked(sessionID)
+
+	var traceID string
+	if isPrompt {
+		traceID = entry.UUID
+	} else if id, ok := conv.turnOf[entry.ParentUUID]; ok {
+		traceID = id
+	} else if conv.lastTurn != "" {
+		traceID = conv.lastTurn
+	} else if m.state != nil {
+		// Parent was ingested by a previous run
+		traceID = m.state.Turn(sessionID)
+	}
+
+	if entry.UUID != "" {
+		conv.turnOf[entry.UUID] = traceID
+	}
+	if entry.Type == "user" {
+		conv.lastInput = timestamp
+	}
+	if isPrompt {
+		conv.generations = nil
+	}
+	if traceID != "" && traceID != conv.lastTurn {
+		conv.lastTurn = traceID
+		if m.state != nil {
+			m.state.SetTurn(sessionID, traceID)
+		}
+	}
+
+	return traceID
+}
+
+// setCurrentTrace remembers the trace of a newly started turn.
+func (m *Monitor) setCurrentTrace(sessionID string, trace *langfuse.Trace) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.conversationLocked(sessionID).current = trace
+}
+
+// updateTurnOutput sets the trace output to the latest assistant answer of
+// the turn. Only turns started in this run can be updated.
+func (m *Monitor) updateTurnOutput(projectPath, sessionID, traceID, output string) error {
+	if output == "" {
+		return nil
+	}
+
+	m.mu.Lock()
+	current := m.conversationLocked(sessionID).current
+	m.mu.Unlock()
+
+	if current == nil || current.ID != traceID {
+		return nil
+	}
+
+	// Only the output changes, so a name set since (e.g. the conversation
+	// summary) is kept
+	return m.createTrace(projectPath, &langfuse.Trace{ID: current.ID, Output: output})
+}
+
+// isHumanPrompt reports whether a user entry was typed by the human, as
+// opposed to carrying tool results or meta information for the current turn.
+func (m *Monitor) isHumanPrompt(entry *Entry) bool {
+	if entry.Type != "user" || entry.IsMeta || entry.IsCompactSummary {
+		return false
+	}
+
+	var strMessage string
+	if err := json.Unmarshal(entry.Message, &strMessage); err == nil {
+		return true
+	}
+
+	var msgContent MessageContent
+	if err := json.Unmarshal(entry.Message, &msgContent); err != nil {
+		return false
+	}
+	for _, block := range msgContent.Content {
+		if block.Type != "tool_result" {
+			return true
+		}
+	}
+	return len(msgContent.Content) == 0 && msgContent.Text != ""
+}
+
+// extractText returns only the text blocks of the message field.
+func (m *Monitor) extractText(rawMessage json.RawMessage) string {
+	var strMessage string
+	if err := json.Unmarshal(rawMessage, &strMessage); err == nil {
+		return strMessage
+	}
+
+	return blocksText(m.extractBlocks(rawMessage))
+}
+
+// blocksText joins the text blocks of a message.
+func blocksText(blocks []ContentBlock) string {
+	var parts []string
+	for _, block := range blocks {
+		if block.Type == "text" && block.Text != "" {
+			parts = append(parts, block.Text)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
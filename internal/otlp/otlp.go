@@ -0,0 +1,602 @@
+// Package otlp exports monitor events as OpenTelemetry spans over OTLP/HTTP
+// using the JSON encoding and the gen_ai.* semantic conventions.
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/user/claude-langfuse-go/internal/langfuse"
+)
+
+// DefaultSettle is how long an observation must go without updates before
+// it is exported. OTLP spans are immutable, while Langfuse observations are
+// created and then updated as a response streams in or a tool call ends.
+const DefaultSettle = 30 * time.Second
+
+// DefaultMaxOpen is how long a span that has started but not ended, such as
+// a running tool call or sub-agent, is held back waiting for its end.
+const DefaultMaxOpen = time.Hour
+
+// exportedTTL is how long exported span IDs are remembered so that late
+// updates do not produce duplicate spans.
+const exportedTTL = time.Hour
+
+// Span kinds and status codes from the OTLP specification.
+const (
+	kindInternal = 1
+	kindClient   = 3
+
+	statusError = 2
+)
+
+// Exporter converts ingestion events to spans and sends them to an OTLP/HTTP
+// traces endpoint.
+type Exporter struct {
+	endpoint    string
+	headers     map[string]string
+	serviceName string
+	httpClient  *http.Client
+	settle      time.Duration
+	maxOpen     time.Duration
+	batchSize   int
+
+	sendMu   sync.Mutex // serialises sends, which run without mu
+	mu       sync.Mutex
+	pending  map[string]*record
+	order    []string          // pending IDs in creation order
+	sessions map[string]string // trace ID -> session ID
+	exported map[string]time.Time
+}
+
+// record accumulates the creates and updates of one observation or trace.
+type record struct {
+	kind     string // trace, generation, span or event
+	id       string
+	traceID  string
+	parentID string
+	name     string
+	model    string
+	level    string
+	status   string
+	userID   string
+	input    string
+	output   string
+	metadata map[string]interface{}
+	usage    map[string]int
+	start    time.Time
+	end      time.Time
+	updated  time.Time
+}
+
+// NewExporter creates an exporter for the collector at endpoint (e.g.
+// http://localhost:4318); spans are posted to endpoint/v1/traces.
+func NewExporter(endpoint string, headers map[string]string, serviceName string, batchSize int) *Exporter {
+	if serviceName == "" {
+		serviceName = "claude-code"
+	}
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	return &Exporter{
+		endpoint:    strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		headers:     headers,
+		serviceName: serviceName,
+		httpClient:  &http.Client{Timeout: 30 * time.Second},
+		settle:      DefaultSettle,
+		maxOpen:     DefaultMaxOpen,
+		batchSize:   batchSize,
+		pending:     make(map[string]*record),
+		sessions:    make(map[string]string),
+		exported:    make(map[string]time.Time),
+	}
+}
+
+// Export merges an event into the span it describes.
+func (e *Exporter) Export(eventType string, body interface{}) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	switch b := body.(type) {
+	case *langfuse.Trace:
+		if b.SessionID != "" {
+			e.sessions[b.ID] = b.SessionID
+		}
+		r := e.recordLocked("trace", rootKey(b.ID), b.ID, now)
+		if r == nil {
+			return nil
+		}
+		setString(&r.name, b.Name)
+		setString(&r.userID, b.UserID)
+		setString(&r.input, encode(b.Input))
+		setString(&r.output, encode(b.Output))
+		mergeMetadata(r, b.Metadata)
+		if b.Timestamp != nil {
+			r.start = *b.Timestamp
+		}
+	case *langfuse.Generation:
+		r := e.recordLocked("generation", b.ID, b.TraceID, now)
+		if r == nil {
+			return nil
+		}
+		setString(&r.parentID, b.ParentObservationID)
+		setString(&r.name, b.Name)
+		setString(&r.model, b.Model)
+		setString(&r.input, encode(b.Input))
+		setString(&r.output, encode(b.Output))
+		mergeMetadata(r, b.Metadata)
+		if b.UsageDetails != nil {
+			r.usage = make(map[string]int, len(b.UsageDetails))
+			for key, n := range b.UsageDetails {
+				r.usage[key] = n
+			}
+		}
+		setTime(&r.start, b.StartTime)
+		setTime(&r.end, b.EndTime)
+		e.touchRootLocked(r, now)
+	case *langfuse.Span:
+		r := e.recordLocked("span", b.ID, b.TraceID, now)
+		if r == nil {
+			return nil
+		}
+		setString(&r.parentID, b.ParentObservationID)
+		setString(&r.name, b.Name)
+		setString(&r.input, encode(b.Input))
+		setString(&r.output, encode(b.Output))
+		setString(&r.level, b.Level)
+		setString(&r.status, b.StatusMessage)
+		mergeMetadata(r, b.Metadata)
+		if b.StartTime != nil {
+			setTime(&r.start, *b.StartTime)
+		}
+		if b.EndTime != nil {
+			setTime(&r.end, *b.EndTime)
+		}
+		e.touchRootLocked(r, now)
+	case *langfuse.EventObservation:
+		r := e.recordLocked("event", b.ID, b.TraceID, now)
+		if r == nil {
+			return nil
+		}
+		setString(&r.parentID, b.ParentObservationID)
+		setString(&r.name, b.Name)
+		setString(&r.input, encode(b.Input))
+		setString(&r.output, encode(b.Output))
+		setString(&r.level, b.Level)
+		setString(&r.status, b.StatusMessage)
+		mergeMetadata(r, b.Metadata)
+		setTime(&r.start, b.StartTime)
+		setTime(&r.end, b.StartTime)
+		e.touchRootLocked(r, now)
+	default:
+		return fmt.Errorf("unsupported %s body %T", eventType, body)
+	}
+	return nil
+}
+
+// recordLocked returns the pending record for key, creating it if needed.
+// It returns nil for spans that have already been exported.
+func (e *Exporter) recordLocked(kind, key, traceID string, now time.Time) *record {
+	if _, done := e.exported[key]; done {
+		return nil
+	}
+	r, ok := e.pending[key]
+	if !ok {
+		r = &record{kind: kind, id: key, traceID: traceID}
+		e.pending[key] = r
+		e.order = append(e.order, key)
+	}
+	setString(&r.traceID, traceID)
+	r.updated = now
+	return r
+}
+
+// touchRootLocked keeps the turn's root span open while its observations
+// are still changing, and extends it to cover them.
+func (e *Exporter) touchRootLocked(r *record, now time.Time) {
+	root, ok := e.pending[rootKey(r.traceID)]
+	if !ok {
+		return
+	}
+	root.updated = now
+	if r.end.After(root.end) {
+		root.end = r.end
+	}
+}
+
+// Flush sends every span that has settled.
+func (e *Exporter) Flush() error {
+	return e.send(false)
+}
+
+// Shutdown sends all remaining spans, settled or not.
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	return e.send(true)
+}
+
+// send exports settled (or, with all, every) pending span in batches.
+// Spans stay pending when a request fails so that they are retried. The
+// lock is released while posting, so Export is never held up by the
+// collector; updates that arrive for a span while it is being sent are lost.
+func (e *Exporter) send(all bool) error {
+	e.sendMu.Lock()
+	defer e.sendMu.Unlock()
+
+	e.mu.Lock()
+	now := time.Now()
+	for key, at := range e.exported {
+		if now.Sub(at) > exportedTTL {
+			delete(e.exported, key)
+			if traceID, ok := strings.CutPrefix(key, rootKey("")); ok {
+				delete(e.sessions, traceID)
+			}
+		}
+	}
+
+	var ready []string
+	for _, key := range e.order {
+		if r := e.pending[key]; all || e.readyLocked(r, now) {
+			ready = append(ready, key)
+		}
+	}
+	e.mu.Unlock()
+
+	defer func() {
+		e.mu.Lock()
+		e.compactLocked()
+		e.mu.Unlock()
+	}()
+
+	for len(ready) > 0 {
+		n := len(ready)
+		if n > e.batchSize {
+			n = e.batchSize
+		}
+		batch := ready[:n]
+		ready = ready[n:]
+
+		e.mu.Lock()
+		spans := make([]span, 0, len(batch))
+		for _, key := range batch {
+			spans = append(spans, e.spanLocked(e.pending[key]))
+		}
+		e.mu.Unlock()
+
+		if err := e.post(spans); err != nil {
+			return err
+		}
+
+		e.mu.Lock()
+		for _, key := range batch {
+			delete(e.pending, key)
+			e.exported[key] = now
+		}
+		e.mu.Unlock()
+	}
+	return nil
+}
+
+// readyLocked reports whether a pending span has settled. Spans that have
+// started but not ended wait for their end, up to maxOpen (must be called
+// with lock held).
+func (e *Exporter) readyLocked(r *record, now time.Time) bool {
+	idle := now.Sub(r.updated)
+	if r.kind == "span" && r.end.IsZero() {
+		return idle >= e.maxOpen
+	}
+	return idle >= e.settle
+}
+
+// compactLocked drops exported spans from the pending order.
+func (e *Exporter) compactLocked() {
+	order := e.order[:0]
+	for _, key := range e.order {
+		if _, ok := e.pending[key]; ok {
+			order = append(order, key)
+		}
+	}
+	e.order = order
+}
+
+// OTLP/JSON payload types.
+type (
+	tracesRequest struct {
+		ResourceSpans []resourceSpans `json:"resourceSpans"`
+	}
+	resourceSpans struct {
+		Resource   resource     `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+	resource struct {
+		Attributes []attribute `json:"attributes"`
+	}
+	scopeSpans struct {
+		Scope scope  `json:"scope"`
+		Spans []span `json:"spans"`
+	}
+	scope struct {
+		Name string `json:"name"`
+	}
+	span struct {
+		TraceID           string      `json:"traceId"`
+		SpanID            string      `json:"spanId"`
+		ParentSpanID      string      `json:"parentSpanId,omitempty"`
+		Name              string      `json:"name"`
+		Kind              int         `json:"kind"`
+		StartTimeUnixNano string      `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+		Attributes        []attribute `json:"attributes,omitempty"`
+		Status            *status     `json:"status,omitempty"`
+	}
+	attribute struct {
+		Key   string   `json:"key"`
+		Value anyValue `json:"value"`
+	}
+	anyValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+	}
+	status struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+// spanLocked converts a record to an OTLP span.
+func (e *Exporter) spanLocked(r *record) span {
+	s := span{
+		TraceID: TraceID(r.traceID),
+		SpanID:  SpanID(r.id),
+		Kind:    kindInternal,
+	}
+	if r.kind != "trace" {
+		parent := r.parentID
+		if parent == "" {
+			parent = rootKey(r.traceID)
+		}
+		s.ParentSpanID = SpanID(parent)
+	}
+
+	start, end := r.start, r.end
+	if start.IsZero() {
+		start = r.updated
+	}
+	if end.Before(start) {
+		end = start
+	}
+	s.StartTimeUnixNano = strconv.FormatInt(start.UnixNano(), 10)
+	s.EndTimeUnixNano = strconv.FormatInt(end.UnixNano(), 10)
+
+	var attrs []attribute
+	add := func(key string, value interface{}) {
+		if a, ok := newAttribute(key, value); ok {
+			attrs = append(attrs, a)
+		}
+	}
+
+	if session := e.sessions[r.traceID]; session != "" {
+		add("session.id", session)
+		add("gen_ai.conversation.id", session)
+	}
+	add("langfuse.observation.type", r.kind)
+
+	switch r.kind {
+	case "trace":
+		s.Name = r.name
+		add("user.id", r.userID)
+		add("input.value", r.input)
+		add("output.value", r.output)
+	case "generation":
+		s.Kind = kindClient
+		s.Name = strings.TrimSpace("chat " + r.model)
+		add("gen_ai.operation.name", "chat")
+		add("gen_ai.provider.name", "anthropic")
+		add("gen_ai.request.model", r.model)
+		add("gen_ai.response.model", r.model)
+		for _, u := range usageAttributes {
+			// Zero counts are reported, but only for buckets Langfuse has
+			if n, ok := r.usage[u.key]; ok {
+				add(u.attribute, n)
+			}
+		}
+		add("gen_ai.input.messages", r.input)
+		add("gen_ai.output.messages", r.output)
+	case "span":
+		s.Name = r.name
+		if sidechain, _ := r.metadata["isSidechain"].(bool); sidechain {
+			s.Name = "invoke_agent " + r.name
+			add("gen_ai.operation.name", "invoke_agent")
+			add("gen_ai.agent.name", r.name)
+		} else if r.name != "thinking" {
+			s.Name = "execute_tool " + r.name
+			add("gen_ai.operation.name", "execute_tool")
+			add("gen_ai.tool.name", r.name)
+			add("gen_ai.tool.call.id", r.id)
+			add("gen_ai.tool.call.arguments", r.input)
+			add("gen_ai.tool.call.result", r.output)
+			break
+		}
+		add("input.value", r.input)
+		add("output.value", r.output)
+	default:
+		s.Name = r.name
+		add("input.value", r.input)
+		add("output.value", r.output)
+	}
+	if s.Name == "" {
+		s.Name = r.kind
+	}
+
+	keys := make([]string, 0, len(r.metadata))
+	for key := range r.metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		add("langfuse.metadata."+key, r.metadata[key])
+	}
+	s.Attributes = attrs
+
+	if r.level == "ERROR" {
+		s.Status = &status{Code: statusError, Message: r.status}
+	}
+	return s
+}
+
+// post sends spans to the collector.
+func (e *Exporter) post(spans []span) error {
+	payload := tracesRequest{ResourceSpans: []resourceSpans{{
+		Resource: resource{Attributes: []attribute{
+			mustAttribute("service.name", e.serviceName),
+		}},
+		ScopeSpans: []scopeSpans{{
+			Scope: scope{Name: "github.com/user/claude-langfuse-go"},
+			Spans: spans,
+		}},
+	}}}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal spans: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send spans: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("otlp collector error: status %d, body: %s", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
+
+// TraceID maps a Langfuse trace ID to a 16-byte OTLP trace ID.
+func TraceID(id string) string {
+	sum := md5.Sum([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// SpanID maps a Langfuse observation ID to an 8-byte OTLP span ID.
+func SpanID(id string) string {
+	sum := md5.Sum([]byte(id))
+	return hex.EncodeToString(sum[:8])
+}
+
+// usageAttributes maps Langfuse usageDetails keys to gen_ai.usage.*
+// attributes.
+var usageAttributes = []struct{ key, attribute string }{
+	{"input", "gen_ai.usage.input_tokens"},
+	{"output", "gen_ai.usage.output_tokens"},
+	{"input_cache_read", "gen_ai.usage.cache_read.input_tokens"},
+	{"input_cache_creation", "gen_ai.usage.cache_creation.input_tokens"},
+}
+
+// rootKey is the record key of a trace's root span.
+func rootKey(traceID string) string {
+	return "trace:" + traceID
+}
+
+// newAttribute converts a value to an OTLP attribute, skipping empty strings
+// and nil. Numbers are always kept, as zero is a meaningful count.
+func newAttribute(key string, value interface{}) (attribute, bool) {
+	var v anyValue
+	switch val := value.(type) {
+	case string:
+		if val == "" {
+			return attribute{}, false
+		}
+		v.StringValue = &val
+	case int:
+		s := strconv.Itoa(val)
+		v.IntValue = &s
+	case float64:
+		v.DoubleValue = &val
+	case bool:
+		v.BoolValue = &val
+	case nil:
+		return attribute{}, false
+	default:
+		s := encode(val)
+		if s == "" {
+			return attribute{}, false
+		}
+		v.StringValue = &s
+	}
+	return attribute{Key: key, Value: v}, true
+}
+
+// mustAttribute builds an attribute that is known to be non-empty.
+func mustAttribute(key, value string) attribute {
+	a, _ := newAttribute(key, value)
+	return a
+}
+
+// encode renders a field value as a string, JSON-encoding structured values.
+func encode(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case json.RawMessage:
+		return string(val)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// mergeMetadata adds metadata keys to a record.
+func mergeMetadata(r *record, metadata map[string]interface{}) {
+	if len(metadata) == 0 {
+		return
+	}
+	if r.metadata == nil {
+		r.metadata = make(map[string]interface{})
+	}
+	for key, value := range metadata {
+		r.metadata[key] = value
+	}
+}
+
+// setString overwrites dst when src is set.
+func setString(dst *string, src string) {
+	if src != "" {
+		*dst = src
+	}
+}
+
+// setTime overwrites dst when src is set.
+func setTime(dst *time.Time, src time.Time) {
+	if !src.IsZero() {
+		*dst = src
+	}
+}
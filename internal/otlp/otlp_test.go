@@ -0,0 +1,257 @@
+package otlp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/user/claude-langfuse-go/internal/langfuse"
+)
+
+// collector is a stand-in OTLP/HTTP collector that records received spans.
+type collector struct {
+	mu     sync.Mutex
+	spans  []span
+	status int
+}
+
+func newCollector(t *testing.T) (*collector, *httptest.Server) {
+	t.Helper()
+	c := &collector{status: http.StatusOK}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("Expected configured headers, got %v", r.Header)
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.status != http.StatusOK {
+			w.WriteHeader(c.status)
+			return
+		}
+
+		var req tracesRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Failed to decode OTLP payload: %v", err)
+		}
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				c.spans = append(c.spans, ss.Spans...)
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return c, server
+}
+
+// attr returns the value of a span attribute as a string.
+func attr(s span, key string) string {
+	for _, a := range s.Attributes {
+		if a.Key != key {
+			continue
+		}
+		switch {
+		case a.Value.StringValue != nil:
+			return *a.Value.StringValue
+		case a.Value.IntValue != nil:
+			return *a.Value.IntValue
+		}
+	}
+	return ""
+}
+
+func exportTurn(t *testing.T, e *Exporter) {
+	t.Helper()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	toolStart, toolEnd := start.Add(2*time.Second), start.Add(3*time.Second)
+
+	events := []struct {
+		eventType string
+		body      interface{}
+	}{
+		{langfuse.EventTraceCreate, &langfuse.Trace{ID: "prompt-1", Name: "claude_code_user", SessionID: "session-1", Input: "Fix it", Timestamp: &start}},
+		{langfuse.EventGenerationCreate, &langfuse.Generation{ID: "gen-1", TraceID: "prompt-1", Name: "claude_response", Model: "claude-sonnet-4",
+			StartTime: start, EndTime: start.Add(time.Second), UsageDetails: map[string]int{"input": 10, "output": 1}}},
+		{langfuse.EventGenerationUpdate, &langfuse.Generation{ID: "gen-1", TraceID: "prompt-1", Name: "claude_response", Model: "claude-sonnet-4",
+			StartTime: start, EndTime: toolStart, Output: "Reading", UsageDetails: map[string]int{"input": 10, "output": 25, "input_cache_read": 0}}},
+		{langfuse.EventSpanCreate, &langfuse.Span{ID: "toolu_1", TraceID: "prompt-1", ParentObservationID: "gen-1", Name: "Read",
+			Input: map[string]interface{}{"file_path": "/a.go"}, StartTime: &toolStart}},
+		{langfuse.EventSpanUpdate, &langfuse.Span{ID: "toolu_1", TraceID: "prompt-1", Output: "no such file", Level: "ERROR",
+			StatusMessage: "no such file", EndTime: &toolEnd}},
+	}
+	for _, ev := range events {
+		if err := e.Export(ev.eventType, ev.body); err != nil {
+			t.Fatalf("Export(%s) failed: %v", ev.eventType, err)
+		}
+	}
+}
+
+func TestExporter_GenAISpans(t *testing.T) {
+	c, server := newCollector(t)
+	e := NewExporter(server.URL, map[string]string{"Authorization": "Bearer token"}, "", 0)
+	e.settle = 0
+
+	exportTurn(t, e)
+	if err := e.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+
+	if len(c.spans) != 3 {
+		t.Fatalf("Expected 3 spans (turn, generation, tool), got %d", len(c.spans))
+	}
+	spans := make(map[string]span)
+	for _, s := range c.spans {
+		spans[s.Name] = s
+		if s.TraceID != TraceID("prompt-1") {
+			t.Errorf("Span %s has trace ID %s, expected %s", s.Name, s.TraceID, TraceID("prompt-1"))
+		}
+		if attr(s, "gen_ai.conversation.id") != "session-1" {
+			t.Errorf("Span %s is missing the session", s.Name)
+		}
+	}
+
+	root, gen, tool := spans["claude_code_user"], spans["chat claude-sonnet-4"], spans["execute_tool Read"]
+	if root.ParentSpanID != "" || root.EndTimeUnixNano != tool.EndTimeUnixNano {
+		t.Errorf("Expected root span covering the turn, got %+v", root)
+	}
+
+	if gen.ParentSpanID != root.SpanID {
+		t.Errorf("Expected generation under the turn, got parent %s", gen.ParentSpanID)
+	}
+	for key, expected := range map[string]string{
+		"gen_ai.operation.name":      "chat",
+		"gen_ai.request.model":       "claude-sonnet-4",
+		"gen_ai.usage.input_tokens":  "10",
+		"gen_ai.usage.output_tokens": "25",
+		"gen_ai.output.messages":     "Reading",
+
+		"gen_ai.usage.cache_read.input_tokens":     "0",
+		"gen_ai.usage.cache_creation.input_tokens": "",
+	} {
+		if got := attr(gen, key); got != expected {
+			t.Errorf("Expected generation %s=%q, got %q", key, expected, got)
+		}
+	}
+
+	if tool.ParentSpanID != gen.SpanID {
+		t.Errorf("Expected tool span under the generation, got parent %s", tool.ParentSpanID)
+	}
+	if attr(tool, "gen_ai.tool.name") != "Read" || attr(tool, "gen_ai.tool.call.arguments") != `{"file_path":"/a.go"}` {
+		t.Errorf("Unexpected tool attributes: %+v", tool.Attributes)
+	}
+	if tool.Status == nil || tool.Status.Code != statusError {
+		t.Errorf("Expected error status on failed tool call, got %+v", tool.Status)
+	}
+
+	// Late updates to exported spans are dropped rather than duplicated
+	if err := e.Export(langfuse.EventSpanUpdate, &langfuse.Span{ID: "toolu_1", Output: "late"}); err != nil {
+		t.Fatalf("Export() failed: %v", err)
+	}
+	if err := e.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() failed: %v", err)
+	}
+	if len(c.spans) != 3 {
+		t.Errorf("Expected late update to be dropped, got %d spans", len(c.spans))
+	}
+}
+
+func TestExporter_SettleAndRetry(t *testing.T) {
+	c, server := newCollector(t)
+	e := NewExporter(server.URL, map[string]string{"Authorization": "Bearer token"}, "", 0)
+
+	exportTurn(t, e)
+	if err := e.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	if len(c.spans) != 0 {
+		t.Errorf("Expected unsettled spans to be held back, got %d", len(c.spans))
+	}
+
+	c.status = http.StatusServiceUnavailable
+	if err := e.Shutdown(context.Background()); err == nil {
+		t.Error("Expected error when the collector is unavailable")
+	}
+
+	c.status = http.StatusOK
+	if err := e.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() failed: %v", err)
+	}
+	if len(c.spans) != 3 {
+		t.Errorf("Expected failed spans to be retried, got %d", len(c.spans))
+	}
+}
+
+func TestExporter_HoldsOpenSpans(t *testing.T) {
+	c, server := newCollector(t)
+	e := NewExporter(server.URL, map[string]string{"Authorization": "Bearer token"}, "", 0)
+	e.settle = 0
+
+	// A tool call that runs for longer than the settle time
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Minute)
+	e.Export(langfuse.EventSpanCreate, &langfuse.Span{ID: "toolu_1", TraceID: "prompt-1", Name: "Bash", StartTime: &start})
+	if err := e.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	if len(c.spans) != 0 {
+		t.Fatalf("Expected the running tool call to be held back, got %d spans", len(c.spans))
+	}
+
+	e.Export(langfuse.EventSpanUpdate, &langfuse.Span{ID: "toolu_1", TraceID: "prompt-1", Output: "done", Level: "ERROR", EndTime: &end})
+	if err := e.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	if len(c.spans) != 1 {
+		t.Fatalf("Expected the tool call once it ended, got %d spans", len(c.spans))
+	}
+	tool := c.spans[0]
+	if tool.EndTimeUnixNano != strconv.FormatInt(end.UnixNano(), 10) || attr(tool, "gen_ai.tool.call.result") != "done" || tool.Status == nil {
+		t.Errorf("Expected the result, end time and status of the tool call, got %+v", tool)
+	}
+
+	// Spans that never end are exported after maxOpen
+	e.maxOpen = 0
+	e.Export(langfuse.EventSpanCreate, &langfuse.Span{ID: "toolu_2", TraceID: "prompt-1", Name: "Bash", StartTime: &start})
+	if err := e.Flush(); err != nil {
+		t.Fatalf("Flush() failed: %v", err)
+	}
+	if len(c.spans) != 2 {
+		t.Errorf("Expected the open span after maxOpen, got %d spans", len(c.spans))
+	}
+}
+
+func TestExporter_ExportDuringSend(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	e := NewExporter(server.URL, nil, "", 0)
+	e.settle = 0
+	e.Export(langfuse.EventTraceCreate, &langfuse.Trace{ID: "prompt-1"})
+	go e.Flush()
+	<-started
+
+	done := make(chan error, 1)
+	go func() { done <- e.Export(langfuse.EventTraceCreate, &langfuse.Trace{ID: "prompt-2"}) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Export() failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected Export() not to wait for the collector")
+	}
+}
@@ -0,0 +1,282 @@
+// Package state persists ingestion checkpoints between monitor runs.
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/user/claude-langfuse-go/internal/config"
+)
+
+// currentVersion is the on-disk format version of the state file.
+const currentVersion = 1
+
+// FileState records how far a conversation file has been ingested.
+type FileState struct {
+	Offset    int64     `json:"offset"`
+	Size      int64     `json:"size"`
+	Inode     uint64    `json:"inode,omitempty"`
+	ModTime   time.Time `json:"modTime"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// TurnState records the trace of the most recent turn in a session, so that
+// entries appended after a restart can still be attached to it.
+type TurnState struct {
+	TraceID   string    `json:"traceId"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Store is a durable checkpoint store for processed files and messages.
+type Store struct {
+	path string
+
+	mu       sync.Mutex
+	files    map[string]FileState
+	messages map[string]time.Time
+	turns    map[string]TurnState
+	dirty    bool
+}
+
+// fileData is the serialized form of a Store.
+type fileData struct {
+	Version  int                  `json:"version"`
+	Files    map[string]FileState `json:"files"`
+	Messages map[string]time.Time `json:"messages"`
+	Turns    map[string]TurnState `json:"turns,omitempty"`
+}
+
+// DefaultFile returns the default state file path.
+func DefaultFile() string {
+	return filepath.Join(config.DefaultConfigDir(), "state.json")
+}
+
+// Open loads the store at path. A missing file yields an empty store.
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:     path,
+		files:    make(map[string]FileState),
+		messages: make(map[string]time.Time),
+		turns:    make(map[string]TurnState),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+
+	var fd fileData
+	if err := json.Unmarshal(data, &fd); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	if fd.Version > currentVersion {
+		return nil, fmt.Errorf("state file %s has unsupported version %d", path, fd.Version)
+	}
+
+	if fd.Files != nil {
+		s.files = fd.Files
+	}
+	if fd.Messages != nil {
+		s.messages = fd.Messages
+	}
+	if fd.Turns != nil {
+		s.turns = fd.Turns
+	}
+
+	return s, nil
+}
+
+// Path returns the location of the state file.
+func (s *Store) Path() string {
+	return s.path
+}
+
+// IsSent reports whether a message UUID has already been sent.
+func (s *Store) IsSent(uuid string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.messages[uuid]
+	return ok
+}
+
+// MarkSent records a message UUID as sent.
+func (s *Store) MarkSent(uuid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages[uuid] = time.Now().UTC()
+	s.dirty = true
+}
+
+// File returns the checkpoint for a conversation file.
+func (s *Store) File(path string) (FileState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fs, ok := s.files[path]
+	return fs, ok
+}
+
+// SetFile updates the checkpoint for a conversation file.
+func (s *Store) SetFile(path string, fs FileState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fs.UpdatedAt = time.Now().UTC()
+	s.files[path] = fs
+	s.dirty = true
+}
+
+// Turn returns the trace ID of the latest turn in a session.
+func (s *Store) Turn(sessionID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.turns[sessionID].TraceID
+}
+
+// SetTurn records the trace ID of the latest turn in a session.
+func (s *Store) SetTurn(sessionID, traceID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.turns[sessionID] = TurnState{TraceID: traceID, UpdatedAt: time.Now().UTC()}
+	s.dirty = true
+}
+
+// Files returns a copy of all file checkpoints.
+func (s *Store) Files() map[string]FileState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	files := make(map[string]FileState, len(s.files))
+	for path, fs := range s.files {
+		files[path] = fs
+	}
+	return files
+}
+
+// Stats returns the number of tracked files and messages.
+func (s *Store) Stats() (files, messages int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.files), len(s.messages)
+}
+
+// Prune drops message and turn records older than maxAge and checkpoints for
+// files that no longer exist or have not been updated within maxAge.
+func (s *Store) Prune(maxAge time.Duration) (files, messages int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := time.Now().Add(-maxAge)
+
+	for uuid, sentAt := range s.messages {
+		if sentAt.Before(cutoff) {
+			delete(s.messages, uuid)
+			messages++
+		}
+	}
+
+	for path, fs := range s.files {
+		_, err := os.Stat(path)
+		if os.IsNotExist(err) || fs.UpdatedAt.Before(cutoff) {
+			delete(s.files, path)
+			files++
+		}
+	}
+
+	turns := 0
+	for sessionID, ts := range s.turns {
+		if ts.UpdatedAt.Before(cutoff) {
+			delete(s.turns, sessionID)
+			turns++
+		}
+	}
+
+	if files > 0 || messages > 0 || turns > 0 {
+		s.dirty = true
+	}
+
+	return files, messages
+}
+
+// ExpireMessages drops message and turn records older than maxAge and
+// returns the number of messages dropped. File checkpoints are kept, as
+// their offsets stop older messages from being read again.
+func (s *Store) ExpireMessages(maxAge time.Duration) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := time.Now().Add(-maxAge)
+	messages := 0
+	for uuid, sentAt := range s.messages {
+		if sentAt.Before(cutoff) {
+			delete(s.messages, uuid)
+			messages++
+		}
+	}
+	for sessionID, ts := range s.turns {
+		if ts.UpdatedAt.Before(cutoff) {
+			delete(s.turns, sessionID)
+			s.dirty = true
+		}
+	}
+	if messages > 0 {
+		s.dirty = true
+	}
+	return messages
+}
+
+// Reset clears all checkpoints and removes the state file.
+func (s *Store) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.files = make(map[string]FileState)
+	s.messages = make(map[string]time.Time)
+	s.turns = make(map[string]TurnState)
+	s.dirty = false
+
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Save writes the store to disk if it has changed since the last save.
+func (s *Store) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(fileData{
+		Version:  currentVersion,
+		Files:    s.files,
+		Messages: s.messages,
+		Turns:    s.turns,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+
+	// Write to a temp file and rename so a crash never leaves a torn file
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+
+	s.dirty = false
+	return nil
+}
@@ -0,0 +1,152 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOpen_MissingFile(t *testing.T) {
+	st, err := Open(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+
+	files, messages := st.Stats()
+	if files != 0 || messages != 0 {
+		t.Errorf("Expected empty store, got %d files, %d messages", files, messages)
+	}
+}
+
+func TestSaveAndReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "state.json")
+
+	st, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+
+	st.MarkSent("msg-1")
+	st.SetFile("/tmp/conv.jsonl", FileState{Offset: 42, Size: 42})
+
+	if err := st.Save(); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() after save failed: %v", err)
+	}
+
+	if !reopened.IsSent("msg-1") {
+		t.Error("msg-1 should be marked as sent after reopening")
+	}
+	if reopened.IsSent("msg-2") {
+		t.Error("msg-2 should not be marked as sent")
+	}
+
+	fs, ok := reopened.File("/tmp/conv.jsonl")
+	if !ok {
+		t.Fatal("Expected file checkpoint to be restored")
+	}
+	if fs.Offset != 42 {
+		t.Errorf("Expected offset 42, got %d", fs.Offset)
+	}
+}
+
+func TestOpen_CorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0600); err != nil {
+		t.Fatalf("Failed to write state: %v", err)
+	}
+
+	if _, err := Open(path); err == nil {
+		t.Error("Expected error for corrupt state file")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.jsonl")
+	if err := os.WriteFile(existing, []byte("{}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	st, err := Open(filepath.Join(dir, "state.json"))
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+
+	st.SetFile(existing, FileState{Offset: 3})
+	st.SetFile(filepath.Join(dir, "deleted.jsonl"), FileState{Offset: 10})
+	st.MarkSent("recent")
+	st.messages["old"] = time.Now().Add(-48 * time.Hour)
+
+	files, messages := st.Prune(24 * time.Hour)
+	if files != 1 {
+		t.Errorf("Expected 1 pruned file, got %d", files)
+	}
+	if messages != 1 {
+		t.Errorf("Expected 1 pruned message, got %d", messages)
+	}
+
+	if _, ok := st.File(existing); !ok {
+		t.Error("Existing file checkpoint should be kept")
+	}
+	if !st.IsSent("recent") {
+		t.Error("Recent message should be kept")
+	}
+}
+
+func TestExpireMessages(t *testing.T) {
+	dir := t.TempDir()
+	st, err := Open(filepath.Join(dir, "state.json"))
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+
+	old := filepath.Join(dir, "old.jsonl")
+	st.SetFile(old, FileState{Offset: 10})
+	st.files[old] = FileState{Offset: 10, UpdatedAt: time.Now().Add(-48 * time.Hour)}
+	st.MarkSent("recent")
+	st.messages["old"] = time.Now().Add(-48 * time.Hour)
+	st.turns["session-old"] = TurnState{TraceID: "t", UpdatedAt: time.Now().Add(-48 * time.Hour)}
+
+	if expired := st.ExpireMessages(24 * time.Hour); expired != 1 {
+		t.Errorf("Expected 1 expired message, got %d", expired)
+	}
+	if st.IsSent("old") || !st.IsSent("recent") {
+		t.Error("Expected only the old message to expire")
+	}
+	if st.Turn("session-old") != "" {
+		t.Error("Expected the old turn to expire")
+	}
+	if _, ok := st.File(old); !ok {
+		t.Error("Expected file checkpoints to be kept")
+	}
+}
+
+func TestReset(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	st, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	st.MarkSent("msg-1")
+	if err := st.Save(); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+
+	if err := st.Reset(); err != nil {
+		t.Fatalf("Reset() failed: %v", err)
+	}
+
+	if st.IsSent("msg-1") {
+		t.Error("Reset should clear sent messages")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Reset should remove the state file")
+	}
+}